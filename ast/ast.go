@@ -7,9 +7,10 @@ import (
 	"my.com/myfile/token"
 )
 
-type Node interface { //定义了AST(语法树)中所有节点必须实现的两个方法
+type Node interface { //定义了AST(语法树)中所有节点必须实现的方法
 	TokenLiteral() string
 	String() string
+	Pos() token.Position //节点对应的词法单元(Token字段)在源码中的位置
 }
 
 // All statement nodes implement this
@@ -27,6 +28,7 @@ type Expression interface { //Ecpression接口，扩展了Node接口，添加了
 
 type Program struct { //每个程序可以有许多语句
 	Statements []Statement
+	Comments   []*Comment //源码中的所有注释，按位置排序，可以根据Pos与语句对应
 }
 
 func (p *Program) TokenLiteral() string { //接受一个程序，然后返回这个程序第一个语句
@@ -37,6 +39,13 @@ func (p *Program) TokenLiteral() string { //接受一个程序，然后返回这
 	}
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string { //
 	var out bytes.Buffer
 
@@ -47,6 +56,27 @@ func (p *Program) String() string { //
 	return out.String()
 }
 
+// Comment 一条 // 或 /* */ 注释
+type Comment struct {
+	Token token.Token // the token.COMMENT token
+}
+
+func (c *Comment) TokenLiteral() string { return c.Token.Literal }
+func (c *Comment) Pos() token.Position  { return c.Token.Pos }
+func (c *Comment) End() token.Position  { return c.Token.End }
+func (c *Comment) String() string       { return c.Token.Literal }
+
+// Text 返回去掉注释符号后的内容
+func (c *Comment) Text() string {
+	text := c.Token.Literal
+	if strings.HasPrefix(text, "//") {
+		return strings.TrimSpace(text[2:])
+	}
+	text = strings.TrimPrefix(text, "/*")
+	text = strings.TrimSuffix(text, "*/")
+	return strings.TrimSpace(text)
+}
+
 // Statements
 type LetStatement struct { //
 	Token token.Token // the token.LET token
@@ -56,6 +86,7 @@ type LetStatement struct { //
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Pos }
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
@@ -79,6 +110,7 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Pos }
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 
@@ -93,21 +125,56 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+// ThrowStatement throw语句，抛出Value的值
+type ThrowStatement struct {
+	Token token.Token // the 'throw' token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode()       {}
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) Pos() token.Position  { return ts.Token.Pos }
+func (ts *ThrowStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(ts.TokenLiteral() + " ")
+	if ts.Value != nil {
+		out.WriteString(ts.Value.String())
+	}
+	out.WriteString(";")
+
+	return out.String()
+}
+
 type BreakStatement struct {
 	Token token.Token // the 'break' token
+	Label *Identifier // break outer; 没有标签时为nil，表示最内层的循环
 }
 
 func (bs *BreakStatement) statementNode()       {}
 func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
-func (bs *BreakStatement) String() string       { return bs.Token.Literal }
+func (bs *BreakStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BreakStatement) String() string {
+	if bs.Label != nil {
+		return bs.Token.Literal + " " + bs.Label.String() + ";"
+	}
+	return bs.Token.Literal + ";"
+}
 
 type ContinueStatement struct {
 	Token token.Token // the 'continue' token
+	Label *Identifier // continue outer; 没有标签时为nil，表示最内层的循环
 }
 
 func (cs *ContinueStatement) statementNode()       {}
 func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
-func (cs *ContinueStatement) String() string       { return cs.Token.Literal }
+func (cs *ContinueStatement) Pos() token.Position  { return cs.Token.Pos }
+func (cs *ContinueStatement) String() string {
+	if cs.Label != nil {
+		return cs.Token.Literal + " " + cs.Label.String() + ";"
+	}
+	return cs.Token.Literal + ";"
+}
 
 type ExpressionStatement struct { //表达式结构体
 	Token      token.Token // the first token of the expression
@@ -116,6 +183,7 @@ type ExpressionStatement struct { //表达式结构体
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position  { return es.Token.Pos }
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -130,6 +198,7 @@ type BlockStatement struct {
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos }
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -148,6 +217,7 @@ type Identifier struct {
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
 func (i *Identifier) String() string       { return i.Value }
 
 type Boolean struct {
@@ -157,6 +227,7 @@ type Boolean struct {
 
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
+func (b *Boolean) Pos() token.Position  { return b.Token.Pos }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
 type IntegerLiteral struct {
@@ -166,8 +237,19 @@ type IntegerLiteral struct {
 
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+
 type PrefixExpression struct {
 	Token    token.Token // The prefix token, e.g. !
 	Operator string
@@ -176,6 +258,7 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos }
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -196,6 +279,7 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -208,6 +292,29 @@ func (ie *InfixExpression) String() string {
 	return out.String()
 }
 
+// AssignExpression 赋值表达式，例如 x = 1 或 x += 1
+type AssignExpression struct {
+	Token    token.Token // The assignment operator token, e.g. +=
+	Target   Expression  // 被赋值的变量(Identifier)或元素(IndexExpression)
+	Operator string
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() token.Position  { return ae.Token.Pos }
+func (ae *AssignExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(ae.Target.String())
+	out.WriteString(" " + ae.Operator + " ")
+	out.WriteString(ae.Value.String())
+	out.WriteString(")")
+
+	return out.String()
+}
+
 type IfExpression struct {
 	Token       token.Token // The 'if' token
 	Condition   Expression
@@ -218,6 +325,7 @@ type IfExpression struct {
 func (ie *IfExpression) statementNode()       {}
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -234,14 +342,47 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+// TryExpression try { } catch (e) { } finally { }，Catch和Finally至少有一个
+type TryExpression struct {
+	Token   token.Token // The 'try' token
+	Body    *BlockStatement
+	Param   *Identifier //catch中绑定错误的变量
+	Catch   *BlockStatement
+	Finally *BlockStatement
+}
+
+func (te *TryExpression) statementNode()       {}
+func (te *TryExpression) expressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) Pos() token.Position  { return te.Token.Pos }
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("try ")
+	out.WriteString(te.Body.String())
+
+	if te.Catch != nil {
+		out.WriteString("catch(" + te.Param.String() + ") ")
+		out.WriteString(te.Catch.String())
+	}
+	if te.Finally != nil {
+		out.WriteString("finally ")
+		out.WriteString(te.Finally.String())
+	}
+
+	return out.String()
+}
+
 type FunctionLiteral struct {
 	Token      token.Token // The 'fn' token
+	Name       string      //函数声明的名字，或者 let f = fn() {} 中的f，匿名函数为空
 	Parameters []*Identifier
 	Body       *BlockStatement
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -259,6 +400,34 @@ func (fl *FunctionLiteral) String() string {
 	return out.String()
 }
 
+// FunctionStatement 函数声明 fn name(params) { }，在所在的块开始执行前就已经定义
+type FunctionStatement struct {
+	Token    token.Token // The 'fn' token
+	Name     *Identifier
+	Function *FunctionLiteral
+}
+
+func (fs *FunctionStatement) statementNode()       {}
+func (fs *FunctionStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *FunctionStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *FunctionStatement) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for _, p := range fs.Function.Parameters {
+		params = append(params, p.String())
+	}
+
+	out.WriteString(fs.TokenLiteral() + " ")
+	out.WriteString(fs.Name.String())
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") ")
+	out.WriteString(fs.Function.Body.String())
+
+	return out.String()
+}
+
 type CallExpression struct {
 	Token     token.Token // The '(' token
 	Function  Expression  // Identifier or FunctionLiteral
@@ -267,6 +436,7 @@ type CallExpression struct {
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position  { return ce.Token.Pos }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -285,7 +455,8 @@ func (ce *CallExpression) String() string {
 
 type ForExpression struct { //For的抽象语法树
 	Token      token.Token
-	Initialize Statement //可以为空
+	Label      *Identifier //outer: for ... 中的标签，可以为空
+	Initialize Statement   //可以为空
 	Condition  Expression
 	Cycleop    Statement
 	Body       *BlockStatement
@@ -300,10 +471,16 @@ type ForExpression struct { //For的抽象语法树
 */
 func (fs *ForExpression) expressionNode()      {}
 func (fs *ForExpression) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForExpression) Pos() token.Position  { return fs.Token.Pos }
 func (fs *ForExpression) String() string {
 	var out bytes.Buffer
+	if fs.Label != nil {
+		out.WriteString(fs.Label.String() + ": ")
+	}
 	out.WriteString("For")
-	out.WriteString(fs.Initialize.String())
+	if fs.Initialize != nil {
+		out.WriteString(fs.Initialize.String())
+	}
 	out.WriteString(fs.Condition.String())
 	out.WriteString(fs.Cycleop.String())
 	//out.WriteString(") ")
@@ -311,16 +488,51 @@ func (fs *ForExpression) String() string {
 	return out.String()
 }
 
+// ForInExpression for v in x { } 或 for k, v in x { }，x可以是数组、哈希表、字符串或区间
+type ForInExpression struct {
+	Token    token.Token
+	Label    *Identifier //outer: for ... 中的标签，可以为空
+	Key      *Identifier //只有一个循环变量时为空
+	Value    *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fi *ForInExpression) expressionNode()      {}
+func (fi *ForInExpression) TokenLiteral() string { return fi.Token.Literal }
+func (fi *ForInExpression) Pos() token.Position  { return fi.Token.Pos }
+func (fi *ForInExpression) String() string {
+	var out bytes.Buffer
+	if fi.Label != nil {
+		out.WriteString(fi.Label.String() + ": ")
+	}
+	out.WriteString("for ")
+	if fi.Key != nil {
+		out.WriteString(fi.Key.String() + ", ")
+	}
+	out.WriteString(fi.Value.String())
+	out.WriteString(" in ")
+	out.WriteString(fi.Iterable.String())
+	out.WriteString(" ")
+	out.WriteString(fi.Body.String())
+	return out.String()
+}
+
 type WhileExpression struct {
 	Token     token.Token
+	Label     *Identifier //outer: while ... 中的标签，可以为空
 	Condition Expression
 	Body      *BlockStatement
 }
 
 func (fs *WhileExpression) expressionNode()      {}
 func (fs *WhileExpression) TokenLiteral() string { return fs.Token.Literal }
+func (fs *WhileExpression) Pos() token.Position  { return fs.Token.Pos }
 func (fs *WhileExpression) String() string {
 	var out bytes.Buffer
+	if fs.Label != nil {
+		out.WriteString(fs.Label.String() + ": ")
+	}
 	out.WriteString("while")
 	out.WriteString(fs.Condition.String())
 	//out.WriteString(") ")
@@ -335,6 +547,7 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
 
 // ArrayLiteral 数组实现
@@ -345,6 +558,7 @@ type ArrayLiteral struct {
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 	elements := []string{}
@@ -365,6 +579,7 @@ type IndexExpression struct {
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position  { return ie.Token.Pos }
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -377,6 +592,35 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// SliceExpression 切片 left[low:high]，low和high都可以省略
+type SliceExpression struct {
+	Token token.Token // The [ token
+	Left  Expression
+	Low   Expression //可以为空，表示从头开始
+	High  Expression //可以为空，表示直到末尾
+}
+
+func (se *SliceExpression) expressionNode()      {}
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SliceExpression) Pos() token.Position  { return se.Token.Pos }
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	out.WriteString("[")
+	if se.Low != nil {
+		out.WriteString(se.Low.String())
+	}
+	out.WriteString(":")
+	if se.High != nil {
+		out.WriteString(se.High.String())
+	}
+	out.WriteString("])")
+
+	return out.String()
+}
+
 type HashLiteral struct {
 	Token token.Token // '{'词法单元
 	Pairs map[Expression]Expression
@@ -384,6 +628,7 @@ type HashLiteral struct {
 
 func (hl *HashLiteral) expressionNode()      {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos }
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
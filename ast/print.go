@@ -0,0 +1,128 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"my.com/myfile/token"
+)
+
+var (
+	tokenType    = reflect.TypeOf(token.Token{})
+	positionType = reflect.TypeOf(token.Position{})
+)
+
+// Fprint 以缩进的树形结构打印node及其所有子节点，每个节点后面附带它的位置，
+// 用于REPL的 :ast 命令和调试
+func Fprint(w io.Writer, node Node) error {
+	pr := &printer{w: w}
+	pr.node(reflect.ValueOf(node), 0)
+	return pr.err
+}
+
+type printer struct {
+	w   io.Writer
+	err error
+}
+
+func (pr *printer) printf(indent int, format string, a ...interface{}) {
+	if pr.err != nil {
+		return
+	}
+	_, pr.err = fmt.Fprintf(pr.w, strings.Repeat("  ", indent)+format+"\n", a...)
+}
+
+// node 打印一个节点，节点的字段依次缩进打印在下面
+func (pr *printer) node(v reflect.Value, indent int) {
+	if !v.IsValid() || (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		pr.printf(indent, "nil")
+		return
+	}
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+
+	header := v.Type().String()
+	if n, ok := v.Interface().(Node); ok {
+		if pos := n.Pos(); pos.IsValid() {
+			header += " (" + pos.String() + ")"
+		}
+	}
+	if c, ok := v.Interface().(*Comment); ok {
+		header += fmt.Sprintf(" %q", c.Token.Literal)
+	}
+
+	s := v.Elem()
+	for i := 0; i < s.NumField(); i++ {
+		field := s.Type().Field(i)
+		if field.Type == tokenType || field.Type == positionType {
+			continue
+		}
+		if isLeaf(field.Type) {
+			header += fmt.Sprintf(" %s=%s", field.Name, leafString(s.Field(i)))
+		}
+	}
+	pr.printf(indent, "%s", header)
+
+	for i := 0; i < s.NumField(); i++ {
+		field := s.Type().Field(i)
+		if field.Type == tokenType || field.Type == positionType || isLeaf(field.Type) {
+			continue
+		}
+		pr.field(field.Name, s.Field(i), indent+1)
+	}
+}
+
+// field 打印子节点、节点的切片或者节点到节点的映射(HashLiteral.Pairs)
+func (pr *printer) field(name string, v reflect.Value, indent int) {
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.Len() == 0 {
+			pr.printf(indent, "%s: []", name)
+			return
+		}
+		pr.printf(indent, "%s:", name)
+		for i := 0; i < v.Len(); i++ {
+			pr.node(v.Index(i), indent+1)
+		}
+	case reflect.Map:
+		pr.printf(indent, "%s:", name)
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { //按位置排序，使输出稳定
+			a, b := keys[i].Interface().(Node).Pos(), keys[j].Interface().(Node).Pos()
+			return a.Offset < b.Offset
+		})
+		for _, key := range keys {
+			pr.printf(indent+1, "Key:")
+			pr.node(key, indent+2)
+			pr.printf(indent+1, "Value:")
+			pr.node(v.MapIndex(key), indent+2)
+		}
+	default:
+		if v.Kind() == reflect.Ptr && v.IsNil() || v.Kind() == reflect.Interface && v.IsNil() {
+			pr.printf(indent, "%s: nil", name)
+			return
+		}
+		pr.printf(indent, "%s:", name)
+		pr.node(v, indent+1)
+	}
+}
+
+// isLeaf 判断字段是否是直接打印在节点那一行的简单值，例如Identifier.Value
+func isLeaf(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func leafString(v reflect.Value) string {
+	if v.Kind() == reflect.String {
+		return fmt.Sprintf("%q", v.String())
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
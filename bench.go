@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"my.com/myfile/bench"
+)
+
+// runBench 实现 wizard bench：用每种执行方式测量每个程序，逐行打印结果
+func runBench(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	minTime := flags.Duration("time", time.Second, "minimum running time of each measurement")
+	backendList := flags.String("backend", "eval,vm", "comma-separated backends to compare, the first one is the baseline")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: wizard bench [flags] [name|file...]")
+		fmt.Fprintf(stderr, "Built-in programs: %s\n", strings.Join(programNames(), ", "))
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	var backends []bench.Backend
+	for _, name := range strings.Split(*backendList, ",") {
+		backend, ok := bench.LookupBackend(strings.TrimSpace(name))
+		if !ok {
+			fmt.Fprintf(stderr, "wizard: unknown backend %q\n", name)
+			return exitUsage
+		}
+		backends = append(backends, backend)
+	}
+
+	programs := bench.Programs
+	if flags.NArg() > 0 {
+		programs = nil
+		for _, arg := range flags.Args() {
+			program, err := loadBenchProgram(arg)
+			if err != nil {
+				fmt.Fprintf(stderr, "wizard: %v\n", err)
+				return exitUsage
+			}
+			programs = append(programs, program)
+		}
+	}
+
+	report := bench.NewReport(stdout)
+	for _, program := range programs {
+		for _, backend := range backends {
+			result, err := bench.Measure(program, backend, *minTime)
+			if err != nil {
+				fmt.Fprintf(stderr, "wizard: %s on %s: %v\n", program.Name, backend.Name, err)
+				return exitRuntimeError
+			}
+			report.Add(result)
+		}
+	}
+	return exitOK
+}
+
+// loadBenchProgram 返回名为arg的内置程序，没有这个名字时把arg当作脚本文件读取
+func loadBenchProgram(arg string) (bench.Program, error) {
+	if program, ok := bench.LookupProgram(arg); ok {
+		return program, nil
+	}
+	src, err := os.ReadFile(arg)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return bench.Program{}, fmt.Errorf("%s is neither a built-in program (%s) nor a file",
+				arg, strings.Join(programNames(), ", "))
+		}
+		return bench.Program{}, err
+	}
+	return bench.Program{Name: filepath.Base(arg), Source: stripShebang(string(src))}, nil
+}
+
+func programNames() []string {
+	names := make([]string, len(bench.Programs))
+	for i, p := range bench.Programs {
+		names[i] = p.Name
+	}
+	return names
+}
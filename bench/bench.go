@@ -0,0 +1,293 @@
+// Package bench 测量Wizard程序的执行速度，供 wizard bench 命令和Go的基准测试使用。
+// 同一组程序可以分别用求值器和字节码虚拟机执行，用来比较两种执行方式以及发现性能退化
+package bench
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"time"
+
+	"my.com/myfile/ast"
+	"my.com/myfile/compiler"
+	"my.com/myfile/evaluator"
+	"my.com/myfile/lexer"
+	"my.com/myfile/object"
+	"my.com/myfile/parser"
+	"my.com/myfile/vm"
+)
+
+// Program 一个用于测量的Wizard程序
+type Program struct {
+	Name     string
+	Source   string
+	Expected string //程序结果的Inspect，用于确认程序正确执行，为空时不检查
+}
+
+// Programs 内置的测量程序，分别侧重函数调用、循环、字符串、数组和哈希表
+var Programs = []Program{
+	{
+		Name:     "fib",
+		Source:   `let fib = fn(n) { if (n < 2) { return n } fib(n - 1) + fib(n - 2) }; fib(20)`,
+		Expected: "6765",
+	},
+	{
+		Name: "loops",
+		Source: `let sum = 0
+for let i = 0 : i < 300 : i += 1 {
+	for let j = 0 : j < 300 : j += 1 {
+		sum += i * j
+	}
+}
+sum`,
+		Expected: "2011522500",
+	},
+	{
+		Name: "strings",
+		Source: `let s = ""
+let i = 0
+while (i < 2000) {
+	s += "ab"
+	i += 1
+}
+len(s)`,
+		Expected: "4000",
+	},
+	{
+		Name: "arrays",
+		Source: `let a = []
+for let i = 0 : i < 1000 : i += 1 {
+	a = push(a, i * 2)
+}
+let sum = 0
+for let i = 0 : i < length(a) : i += 1 {
+	sum += a[i]
+}
+sum`,
+		Expected: "999000",
+	},
+	{
+		Name: "hashes",
+		Source: `let h = {}
+for let i = 0 : i < 500 : i += 1 {
+	h[i] = i * 2
+	h["k" + "ey"] = i
+}
+let sum = 0
+for let round = 0 : round < 10 : round += 1 {
+	for let i = 0 : i < 500 : i += 1 {
+		sum += h[i]
+	}
+}
+sum + h["key"]`,
+		Expected: "2495499",
+	},
+}
+
+// Backend 一种执行方式
+type Backend struct {
+	Name string
+	// Prepare 在计时之前完成执行前的准备工作，例如vm在这里编译程序，所以测量结果只包括执行的时间
+	Prepare func(program *ast.Program) (Runner, error)
+}
+
+// Runner 执行一次准备好的程序
+type Runner func(env *object.Environment) object.Object
+
+// Backends 可以比较的执行方式，第一个是遍历语法树的求值器
+var Backends = []Backend{
+	{Name: "eval", Prepare: func(program *ast.Program) (Runner, error) {
+		return func(env *object.Environment) object.Object {
+			return evaluator.Eval(program, env)
+		}, nil
+	}},
+	{Name: "vm", Prepare: func(program *ast.Program) (Runner, error) {
+		c := compiler.New()
+		if err := c.Compile(program); err != nil {
+			return nil, err
+		}
+		bytecode := c.Bytecode()
+		return func(env *object.Environment) object.Object {
+			return vm.New(bytecode, env).Run()
+		}, nil
+	}},
+}
+
+// LookupProgram 返回名为name的内置程序
+func LookupProgram(name string) (Program, bool) {
+	for _, p := range Programs {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Program{}, false
+}
+
+// LookupBackend 返回名为name的执行方式
+func LookupBackend(name string) (Backend, bool) {
+	for _, b := range Backends {
+		if b.Name == name {
+			return b, true
+		}
+	}
+	return Backend{}, false
+}
+
+// Parse 解析程序的源码，有语法错误时返回第一个错误
+func (p Program) Parse() (*ast.Program, error) {
+	ps := parser.New(lexer.NewFile(p.Name, p.Source))
+	program := ps.ParseProgram()
+	if diagnostics := ps.Diagnostics(); len(diagnostics) != 0 {
+		return nil, diagnostics[0]
+	}
+	return program, nil
+}
+
+// Run 执行一次准备好的程序，返回执行的步数：求值器求值的节点数或者vm执行的指令数。
+// 程序出错或者结果与expected不同时返回错误
+func Run(run Runner, expected string) (steps int64, err error) {
+	rt := &object.Runtime{Stdin: strings.NewReader(""), Stdout: io.Discard, Stderr: io.Discard}
+	result := run(object.NewEnvironmentWithRuntime(rt))
+
+	if errObj, ok := result.(*object.Error); ok {
+		return 0, fmt.Errorf("%s", errObj.Inspect())
+	}
+	if expected != "" && (result == nil || result.Inspect() != expected) {
+		got := "nil"
+		if result != nil {
+			got = result.Inspect()
+		}
+		return 0, fmt.Errorf("wrong result: got %s, want %s", got, expected)
+	}
+	return rt.Steps(), nil
+}
+
+// Result 一个程序在一种执行方式下的测量结果
+type Result struct {
+	Program  string
+	Backend  string
+	N        int //执行的次数
+	Duration time.Duration
+	Allocs   uint64 //内存分配的总次数
+	Bytes    uint64 //分配的总字节数
+	Steps    int64
+}
+
+func (r Result) NsPerOp() int64      { return r.Duration.Nanoseconds() / int64(r.N) }
+func (r Result) AllocsPerOp() uint64 { return r.Allocs / uint64(r.N) }
+func (r Result) BytesPerOp() uint64  { return r.Bytes / uint64(r.N) }
+
+// StepsPerSec 每秒执行的步数。求值器的一步是求值一个语法树节点，vm的一步是执行一条指令，
+// 所以只能在同一种执行方式的结果之间比较
+func (r Result) StepsPerSec() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Steps) / r.Duration.Seconds()
+}
+
+// Measure 反复执行程序，直到总时间至少为minTime。与testing包一样，
+// 先执行少量次数，再根据每次的耗时估计需要执行的次数。解析和编译不计入测量结果
+func Measure(p Program, backend Backend, minTime time.Duration) (Result, error) {
+	program, err := p.Parse()
+	if err != nil {
+		return Result{}, err
+	}
+	run, err := backend.Prepare(program)
+	if err != nil {
+		return Result{}, err
+	}
+	if _, err := Run(run, p.Expected); err != nil { //预热，同时检查程序的结果
+		return Result{}, err
+	}
+
+	n := 1
+	for {
+		result, err := measureN(p, run, backend, n)
+		if err != nil {
+			return Result{}, err
+		}
+		if result.Duration >= minTime || n >= 1e9 {
+			return result, nil
+		}
+
+		perOp := max(result.Duration.Nanoseconds()/int64(n), 1)
+		next := int(minTime.Nanoseconds() * 6 / 5 / perOp) //多估计20%，避免刚好不够再测一轮
+		n = min(max(next, n+1), 100*n)
+	}
+}
+
+func measureN(p Program, run Runner, backend Backend, n int) (Result, error) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	var steps int64
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		s, err := Run(run, p.Expected)
+		if err != nil {
+			return Result{}, err
+		}
+		steps += s
+	}
+	duration := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	return Result{
+		Program:  p.Name,
+		Backend:  backend.Name,
+		N:        n,
+		Duration: duration,
+		Allocs:   after.Mallocs - before.Mallocs,
+		Bytes:    after.TotalAlloc - before.TotalAlloc,
+		Steps:    steps,
+	}, nil
+}
+
+// Report 逐行输出测量结果，每个程序的第一个结果是比较速度的基准
+type Report struct {
+	w         io.Writer
+	baselines map[string]Result
+}
+
+const reportFormat = "%-10s %-6s %14s %12s %12s %10s %8s\n"
+
+// NewReport 创建Report并输出表头
+func NewReport(w io.Writer) *Report {
+	fmt.Fprintf(w, reportFormat, "program", "backend", "ns/op", "allocs/op", "B/op", "steps/s", "speedup")
+	return &Report{w: w, baselines: make(map[string]Result)}
+}
+
+// Add 输出一行结果，speedup是基准的ns/op与这个结果的ns/op之比
+func (r *Report) Add(result Result) {
+	baseline, ok := r.baselines[result.Program]
+	if !ok {
+		baseline = result
+		r.baselines[result.Program] = result
+	}
+
+	fmt.Fprintf(r.w, reportFormat,
+		result.Program,
+		result.Backend,
+		fmt.Sprint(result.NsPerOp()),
+		fmt.Sprint(result.AllocsPerOp()),
+		fmt.Sprint(result.BytesPerOp()),
+		humanize(result.StepsPerSec()),
+		fmt.Sprintf("%.2fx", float64(baseline.NsPerOp())/float64(max(result.NsPerOp(), 1))),
+	)
+}
+
+// humanize 用k、M、G表示较大的数
+func humanize(v float64) string {
+	switch {
+	case v >= 1e9:
+		return fmt.Sprintf("%.2fG", v/1e9)
+	case v >= 1e6:
+		return fmt.Sprintf("%.2fM", v/1e6)
+	case v >= 1e3:
+		return fmt.Sprintf("%.2fk", v/1e3)
+	}
+	return fmt.Sprintf("%.0f", v)
+}
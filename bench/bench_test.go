@@ -0,0 +1,101 @@
+package bench
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPrograms(t *testing.T) {
+	for _, p := range Programs {
+		program, err := p.Parse()
+		if err != nil {
+			t.Fatalf("%s: %s", p.Name, err)
+		}
+		for _, backend := range Backends {
+			run, err := backend.Prepare(program)
+			if err != nil {
+				t.Fatalf("%s/%s: %s", p.Name, backend.Name, err)
+			}
+			steps, err := Run(run, p.Expected)
+			if err != nil {
+				t.Errorf("%s/%s: %s", p.Name, backend.Name, err)
+			}
+			if steps == 0 {
+				t.Errorf("%s/%s: steps were not counted", p.Name, backend.Name)
+			}
+		}
+	}
+}
+
+func TestRunChecksResult(t *testing.T) {
+	p := Program{Name: "wrong", Source: "1 + 1", Expected: "3"}
+	program, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, _ := Backends[0].Prepare(program)
+	_, err = Run(run, p.Expected)
+	if err == nil || err.Error() != "wrong result: got 2, want 3" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+
+	if _, err := (Program{Name: "bad.wz", Source: "let = 1"}).Parse(); err == nil {
+		t.Errorf("expected a parser error")
+	}
+}
+
+func TestMeasureAndReport(t *testing.T) {
+	p := Program{Name: "small", Source: "let a = [1, 2, 3]; a[1] + a[2]", Expected: "5"}
+
+	var out bytes.Buffer
+	report := NewReport(&out)
+	for _, backend := range Backends {
+		result, err := Measure(p, backend, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.N < 1 || result.Steps == 0 || result.Allocs == 0 {
+			t.Errorf("%s: incomplete result %+v", backend.Name, result)
+		}
+		report.Add(result)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1+len(Backends) {
+		t.Fatalf("wrong number of lines. got=%q", out.String())
+	}
+	if !strings.HasPrefix(lines[0], "program") || !strings.HasSuffix(lines[1], "1.00x") {
+		t.Errorf("wrong report. got=%q", out.String())
+	}
+}
+
+// BenchmarkPrograms 用每种执行方式执行每个内置程序，例如
+//
+//	go test -bench 'Programs/fib' -benchmem
+func BenchmarkPrograms(b *testing.B) {
+	for _, p := range Programs {
+		program, err := p.Parse()
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, backend := range Backends {
+			run, err := backend.Prepare(program)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Run(p.Name+"/"+backend.Name, func(b *testing.B) {
+				b.ReportAllocs()
+				var steps int64
+				for i := 0; i < b.N; i++ {
+					s, err := Run(run, p.Expected)
+					if err != nil {
+						b.Fatal(err)
+					}
+					steps += s
+				}
+				b.ReportMetric(float64(steps)/b.Elapsed().Seconds(), "steps/s")
+			})
+		}
+	}
+}
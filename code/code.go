@@ -0,0 +1,272 @@
+// Package code 定义字节码的指令格式：每条指令由一个字节的操作码和若干个大端序的操作数组成
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+type Instructions []byte
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota //把常量池中的常量压入栈
+	OpNull
+	OpTrue
+	OpFalse
+	OpPop
+	OpDup  //复制栈顶的值
+	OpDup2 //复制栈顶的两个值，用于 a[i] += v
+
+	// 二元运算，弹出右操作数和左操作数，压入结果
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpPow
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpShl
+	OpShr
+	OpEqual
+	OpNotEqual
+	OpLess
+	OpGreater
+	OpLessEqual
+	OpGreaterEqual
+	OpRange
+	OpRangeInclusive
+
+	// 一元运算
+	OpMinus
+	OpBang
+	OpBitNot
+
+	OpJump           //无条件跳转
+	OpJumpNotTruthy  //弹出栈顶的值，为假时跳转
+	OpJumpFalsyKeep  //&&：栈顶的值为假时保留它并跳转，否则弹出
+	OpJumpTruthyKeep //||：栈顶的值为真时保留它并跳转，否则弹出
+
+	OpGetGlobal    //操作数是变量名在常量池中的下标，全局变量保存在object.Environment中
+	OpDefineGlobal //let定义全局变量
+	OpSetGlobal    //给已经定义的全局变量赋值
+	OpGetLocal     //操作数是局部变量在栈帧中的位置
+	OpSetLocal
+	OpGetCell //被闭包引用的局部变量保存在Cell中，通过Cell读写
+	OpSetCell
+	OpLoadCell //把局部变量的Cell本身压入栈，用于创建闭包
+	OpGetFree  //读写闭包引用的外层变量
+	OpSetFree
+	OpLoadFree
+	OpClearLocals //进入块作用域时清空块中声明的变量，每次循环都得到新的变量
+
+	OpArray      //操作数是元素个数
+	OpHash       //操作数是键值对的个数
+	OpIndex      //弹出下标和被索引的值
+	OpCheckIndex //检查栈顶的两个值能否进行下标赋值，不弹出
+	OpSetIndex   //弹出值、下标和数组或哈希表，赋值后压入值
+	OpSlice      //弹出high、low和被切片的值，压入切片，省略的下标为null
+	OpIterator   //把栈顶的值替换为遍历它的迭代器
+	OpIterNext   //操作数是遍历结束时跳转到的位置和保存迭代器的局部变量，没有结束时压入键和值
+
+	OpClosure     //操作数是函数在常量池中的下标和引用的外层变量的个数
+	OpCall        //操作数是参数个数
+	OpReturnValue //返回栈顶的值
+	OpReturnNil   //程序的最后一个语句不是表达式时，结果为nil
+
+	OpThrow      //弹出一个值，作为错误抛出
+	OpRethrow    //弹出一个*object.Error并重新抛出
+	OpSetupTry   //操作数是发生错误时跳转到的位置
+	OpPopTry     //离开try块
+	OpErrorValue //把栈顶的*object.Error转换为catch中可以使用的ErrorValue
+	OpMark       //把栈的高度保存到局部变量中，break和continue用OpUnwind恢复
+	OpUnwind
+)
+
+type Definition struct {
+	Name          string
+	OperandWidths []int //每个操作数占用的字节数
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpNull:     {"OpNull", []int{}},
+	OpTrue:     {"OpTrue", []int{}},
+	OpFalse:    {"OpFalse", []int{}},
+	OpPop:      {"OpPop", []int{}},
+	OpDup:      {"OpDup", []int{}},
+	OpDup2:     {"OpDup2", []int{}},
+
+	OpAdd:          {"OpAdd", []int{}},
+	OpSub:          {"OpSub", []int{}},
+	OpMul:          {"OpMul", []int{}},
+	OpDiv:          {"OpDiv", []int{}},
+	OpMod:          {"OpMod", []int{}},
+	OpPow:          {"OpPow", []int{}},
+	OpBitAnd:       {"OpBitAnd", []int{}},
+	OpBitOr:        {"OpBitOr", []int{}},
+	OpBitXor:       {"OpBitXor", []int{}},
+	OpShl:          {"OpShl", []int{}},
+	OpShr:          {"OpShr", []int{}},
+	OpEqual:        {"OpEqual", []int{}},
+	OpNotEqual:     {"OpNotEqual", []int{}},
+	OpLess:         {"OpLess", []int{}},
+	OpGreater:      {"OpGreater", []int{}},
+	OpLessEqual:    {"OpLessEqual", []int{}},
+	OpGreaterEqual: {"OpGreaterEqual", []int{}},
+	OpRange:        {"OpRange", []int{}},
+
+	OpRangeInclusive: {"OpRangeInclusive", []int{}},
+
+	OpMinus:  {"OpMinus", []int{}},
+	OpBang:   {"OpBang", []int{}},
+	OpBitNot: {"OpBitNot", []int{}},
+
+	OpJump:           {"OpJump", []int{2}},
+	OpJumpNotTruthy:  {"OpJumpNotTruthy", []int{2}},
+	OpJumpFalsyKeep:  {"OpJumpFalsyKeep", []int{2}},
+	OpJumpTruthyKeep: {"OpJumpTruthyKeep", []int{2}},
+
+	OpGetGlobal:    {"OpGetGlobal", []int{2}},
+	OpDefineGlobal: {"OpDefineGlobal", []int{2}},
+	OpSetGlobal:    {"OpSetGlobal", []int{2}},
+	OpGetLocal:     {"OpGetLocal", []int{2}},
+	OpSetLocal:     {"OpSetLocal", []int{2}},
+	OpGetCell:      {"OpGetCell", []int{2}},
+	OpSetCell:      {"OpSetCell", []int{2}},
+	OpLoadCell:     {"OpLoadCell", []int{2}},
+	OpGetFree:      {"OpGetFree", []int{2}},
+	OpSetFree:      {"OpSetFree", []int{2}},
+	OpLoadFree:     {"OpLoadFree", []int{2}},
+	OpClearLocals:  {"OpClearLocals", []int{2, 2}},
+
+	OpArray:      {"OpArray", []int{2}},
+	OpHash:       {"OpHash", []int{2}},
+	OpIndex:      {"OpIndex", []int{}},
+	OpCheckIndex: {"OpCheckIndex", []int{}},
+	OpSetIndex:   {"OpSetIndex", []int{}},
+	OpSlice:      {"OpSlice", []int{}},
+	OpIterator:   {"OpIterator", []int{}},
+	OpIterNext:   {"OpIterNext", []int{2, 2}},
+
+	OpClosure:     {"OpClosure", []int{2, 2}},
+	OpCall:        {"OpCall", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpReturnNil:   {"OpReturnNil", []int{}},
+
+	OpThrow:      {"OpThrow", []int{}},
+	OpRethrow:    {"OpRethrow", []int{}},
+	OpSetupTry:   {"OpSetupTry", []int{2}},
+	OpPopTry:     {"OpPopTry", []int{}},
+	OpErrorValue: {"OpErrorValue", []int{}},
+	OpMark:       {"OpMark", []int{2}},
+	OpUnwind:     {"OpUnwind", []int{2}},
+}
+
+// Lookup 返回操作码的定义
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	return def, nil
+}
+
+// Make 生成一条指令，操作数超出宽度时被截断
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+// ReadOperands 解码一条指令的操作数，返回操作数和它们占用的字节数
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 { return uint8(ins[0]) }
+
+// String 反汇编，每行一条指令，以指令的偏移量开头
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	operandCount := len(def.OperandWidths)
+
+	if len(operands) != operandCount {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n",
+			len(operands), operandCount)
+	}
+
+	switch operandCount {
+	case 0:
+		return def.Name
+	case 1:
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	}
+
+	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+}
@@ -0,0 +1,877 @@
+// Package compiler 把语法树编译成字节码，由vm执行。
+// 编译后的程序与求值器的语义相同：块作用域、闭包共享外层变量、函数提升、try/catch/finally以及带标签的break和continue
+package compiler
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"my.com/myfile/ast"
+	"my.com/myfile/code"
+	"my.com/myfile/object"
+	"my.com/myfile/token"
+)
+
+// Bytecode 编译的结果，Main是顶层代码编译成的函数
+type Bytecode struct {
+	Main      *object.CompiledFunction
+	Constants []object.Object
+}
+
+type Compiler struct {
+	constants []object.Object
+	names     map[string]int //变量名在常量池中的下标
+
+	symbolTable *SymbolTable
+	scopes      []*compilationScope
+	functions   []*object.CompiledFunction     //编译得到的所有函数，编译结束时设置它们的常量池
+	hoisted     map[*ast.FunctionStatement]int //保存提升的函数的闭包的隐藏局部变量
+
+	pos token.Position //正在编译的节点的位置，记录到生成的指令中
+	err error
+}
+
+// compilationScope 正在编译的一个函数
+type compilationScope struct {
+	instructions code.Instructions
+	positions    []object.SourcePos
+	localSites   []localSite //读写局部变量的指令
+	controls     []*control  //包围当前位置的循环和try，从外到内
+}
+
+// localSite 一条OpGetLocal或OpSetLocal指令。函数编译完成后，
+// 被闭包引用的变量的指令改为OpGetCell和OpSetCell
+type localSite struct {
+	offset int
+	symbol *Symbol
+}
+
+// control 一个循环或者一个正在生效的try。break、continue和return离开try时
+// 要先移除错误处理并执行finally
+type control struct {
+	loop bool
+
+	// 循环
+	label     string
+	mark      int   //保存循环开始时栈高度的局部变量
+	breaks    []int //需要回填的跳转指令
+	continues []int
+
+	// try
+	finally *ast.BlockStatement
+}
+
+var infixOps = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"%":  code.OpMod,
+	"**": code.OpPow,
+	"&":  code.OpBitAnd,
+	"|":  code.OpBitOr,
+	"^":  code.OpBitXor,
+	"<<": code.OpShl,
+	">>": code.OpShr,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+	"<":  code.OpLess,
+	">":  code.OpGreater,
+	"<=": code.OpLessEqual,
+	">=": code.OpGreaterEqual,
+	"..": code.OpRange,
+
+	"..=": code.OpRangeInclusive,
+}
+
+var prefixOps = map[string]code.Opcode{
+	"-": code.OpMinus,
+	"!": code.OpBang,
+	"~": code.OpBitNot,
+}
+
+func New() *Compiler {
+	return &Compiler{
+		names:       make(map[string]int),
+		symbolTable: NewSymbolTable(),
+		hoisted:     make(map[*ast.FunctionStatement]int),
+	}
+}
+
+// Compile 编译整个程序，程序的值是最后一个语句的值
+func (c *Compiler) Compile(program *ast.Program) error {
+	c.enterScope()
+	c.pos = program.Pos()
+
+	c.hoistFunctions(program.Statements)
+	for i, stmt := range program.Statements {
+		if es, ok := stmt.(*ast.ExpressionStatement); ok && i == len(program.Statements)-1 {
+			c.compileExpression(es.Expression)
+			c.emit(code.OpReturnValue)
+			break
+		}
+		c.compileStatement(stmt)
+	}
+	if n := len(program.Statements); n == 0 || !isExpressionStatement(program.Statements[n-1]) {
+		c.emit(code.OpReturnNil) //与求值器一样，以let等语句结尾的程序的值为nil
+	}
+
+	c.newFunction("", nil, c.leaveScope(), c.symbolTable)
+	return c.err
+}
+
+func isExpressionStatement(stmt ast.Statement) bool {
+	_, ok := stmt.(*ast.ExpressionStatement)
+	return ok
+}
+
+// Bytecode 返回编译的结果，必须在Compile成功之后调用
+func (c *Compiler) Bytecode() *Bytecode {
+	for _, fn := range c.functions {
+		fn.Constants = c.constants
+	}
+	return &Bytecode{
+		Main:      c.functions[len(c.functions)-1],
+		Constants: c.constants,
+	}
+}
+
+func (c *Compiler) errorf(format string, a ...interface{}) {
+	if c.err == nil {
+		c.err = fmt.Errorf("%s: %s", c.pos, fmt.Sprintf(format, a...))
+	}
+}
+
+func (c *Compiler) compileStatement(stmt ast.Statement) {
+	saved := c.pos
+	c.pos = stmt.Pos()
+	defer func() { c.pos = saved }()
+
+	switch stmt := stmt.(type) {
+	case *ast.ExpressionStatement:
+		c.compileExpression(stmt.Expression)
+		c.emit(code.OpPop)
+
+	case *ast.LetStatement:
+		c.compileExpression(stmt.Value)
+		sym := c.symbolTable.Define(stmt.Name.Value)
+		sym.Defined = true
+		c.emitDefine(sym)
+
+	case *ast.ReturnStatement:
+		c.compileExpression(stmt.ReturnValue)
+		c.leaveControls(0)
+		c.emit(code.OpReturnValue)
+
+	case *ast.ThrowStatement:
+		c.compileExpression(stmt.Value)
+		c.emit(code.OpThrow)
+
+	case *ast.BreakStatement:
+		c.compileLoopJump(stmt.Label, true)
+
+	case *ast.ContinueStatement:
+		c.compileLoopJump(stmt.Label, false)
+
+	case *ast.FunctionStatement:
+		//函数已经在块开始时定义，与求值器一样，执行到语句时重新绑定块开始时创建的闭包，
+		//覆盖之前被赋的其他值
+		c.emit(code.OpGetLocal, c.hoisted[stmt])
+		c.emitDefine(c.symbolTable.Define(stmt.Name.Value))
+
+	case *ast.BlockStatement:
+		c.enterBlock()
+		c.compileBlock(stmt.Statements, false, true)
+		c.leaveBlock()
+
+	default:
+		c.errorf("unsupported statement %T", stmt)
+	}
+}
+
+func (c *Compiler) compileExpression(exp ast.Expression) {
+	if exp == nil {
+		c.emit(code.OpNull)
+		return
+	}
+
+	saved := c.pos
+	c.pos = exp.Pos()
+	defer func() { c.pos = saved }()
+
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: exp.Value}))
+
+	case *ast.FloatLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Float{Value: exp.Value}))
+
+	case *ast.StringLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: exp.Value}))
+
+	case *ast.Boolean:
+		if exp.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.Identifier:
+		c.emitGet(c.symbolTable.Resolve(exp.Value))
+
+	case *ast.PrefixExpression:
+		c.compileExpression(exp.Right)
+		op, ok := prefixOps[exp.Operator]
+		if !ok {
+			c.errorf("unknown operator %s", exp.Operator)
+			return
+		}
+		c.emit(op)
+
+	case *ast.InfixExpression:
+		c.compileInfixExpression(exp)
+
+	case *ast.AssignExpression:
+		c.compileAssignExpression(exp)
+
+	case *ast.IfExpression:
+		c.compileExpression(exp.Condition)
+		jumpNotTruthy := c.emit(code.OpJumpNotTruthy, 9999)
+
+		c.compileScopedBlock(exp.Consequence)
+		jump := c.emit(code.OpJump, 9999)
+
+		c.changeOperand(jumpNotTruthy, c.offset())
+		if exp.Alternative != nil {
+			c.compileScopedBlock(exp.Alternative)
+		} else {
+			c.emit(code.OpNull)
+		}
+		c.changeOperand(jump, c.offset())
+
+	case *ast.WhileExpression:
+		c.compileWhileExpression(exp)
+
+	case *ast.ForExpression:
+		c.compileForExpression(exp)
+	case *ast.ForInExpression:
+		c.compileForInExpression(exp)
+
+	case *ast.TryExpression:
+		c.compileTryExpression(exp)
+
+	case *ast.FunctionLiteral:
+		c.compileFunctionLiteral(exp)
+
+	case *ast.CallExpression:
+		c.compileExpression(exp.Function)
+		for _, arg := range exp.Arguments {
+			c.compileExpression(arg)
+		}
+		if len(exp.Arguments) > math.MaxUint8 {
+			c.errorf("too many arguments: %d", len(exp.Arguments))
+		}
+		c.pos = exp.Function.Pos() //调用栈和调用产生的错误都指向被调用的表达式，与求值器相同
+		c.emit(code.OpCall, len(exp.Arguments))
+
+	case *ast.ArrayLiteral:
+		for _, el := range exp.Elements {
+			c.compileExpression(el)
+		}
+		c.emit(code.OpArray, len(exp.Elements))
+
+	case *ast.HashLiteral:
+		keys := make([]ast.Expression, 0, len(exp.Pairs))
+		for k := range exp.Pairs {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { //按源码中的顺序求值
+			return keys[i].Pos().Offset < keys[j].Pos().Offset
+		})
+		for _, k := range keys {
+			c.compileExpression(k)
+			c.compileExpression(exp.Pairs[k])
+		}
+		c.emit(code.OpHash, len(exp.Pairs))
+
+	case *ast.IndexExpression:
+		c.compileExpression(exp.Left)
+		c.compileExpression(exp.Index)
+		c.emit(code.OpIndex)
+
+	case *ast.SliceExpression:
+		c.compileExpression(exp.Left)
+		for _, bound := range []ast.Expression{exp.Low, exp.High} {
+			if bound == nil {
+				c.emit(code.OpNull) //省略的下标
+			} else {
+				c.compileExpression(bound)
+			}
+		}
+		c.emit(code.OpSlice)
+
+	default:
+		c.errorf("unsupported expression %T", exp)
+	}
+}
+
+func (c *Compiler) compileInfixExpression(exp *ast.InfixExpression) {
+	if exp.Operator == "&&" || exp.Operator == "||" { //短路求值，结果是决定结果的那个操作数
+		c.compileExpression(exp.Left)
+		op := code.OpJumpFalsyKeep
+		if exp.Operator == "||" {
+			op = code.OpJumpTruthyKeep
+		}
+		jump := c.emit(op, 9999)
+		c.compileExpression(exp.Right)
+		c.changeOperand(jump, c.offset())
+		return
+	}
+
+	c.compileExpression(exp.Left)
+	c.compileExpression(exp.Right)
+	c.emitInfix(exp.Operator)
+}
+
+func (c *Compiler) emitInfix(operator string) {
+	op, ok := infixOps[operator]
+	if !ok {
+		c.errorf("unknown operator %s", operator)
+		return
+	}
+	c.emit(op)
+}
+
+// compileAssignExpression 赋值表达式的值是赋给变量的值，复合赋值先取出旧值再计算
+func (c *Compiler) compileAssignExpression(exp *ast.AssignExpression) {
+	compound := exp.Operator != "="
+	operator := exp.Operator[:len(exp.Operator)-1]
+
+	switch target := exp.Target.(type) {
+	case *ast.Identifier:
+		sym := c.symbolTable.Resolve(target.Value)
+		if compound {
+			c.emitGet(sym)
+		}
+		c.compileExpression(exp.Value)
+		if compound {
+			c.emitInfix(operator)
+		}
+		c.emit(code.OpDup)
+		c.emitAssign(sym)
+
+	case *ast.IndexExpression:
+		c.compileExpression(target.Left)
+		c.compileExpression(target.Index)
+		c.emit(code.OpCheckIndex)
+		if compound {
+			c.emit(code.OpDup2)
+			c.emit(code.OpIndex)
+		}
+		c.compileExpression(exp.Value)
+		if compound {
+			c.emitInfix(operator)
+		}
+		c.emit(code.OpSetIndex)
+
+	default:
+		c.errorf("cannot assign to %s", exp.Target.String())
+	}
+}
+
+// compileScopedBlock 在新的作用域中编译块，块的值留在栈上
+func (c *Compiler) compileScopedBlock(block *ast.BlockStatement) {
+	c.enterBlock()
+	c.compileBlock(block.Statements, true, true)
+	c.leaveBlock()
+}
+
+// compileBlock 编译当前作用域中的语句。wantValue为true时最后一个表达式语句的值留在栈上，
+// 没有值时留下NULL。clear为true时先清空块中声明的变量，使每次执行块都得到新的变量
+func (c *Compiler) compileBlock(statements []ast.Statement, wantValue, clear bool) {
+	first, count := c.declare(statements)
+	if clear && count > 0 {
+		c.emit(code.OpClearLocals, first, count)
+	}
+	c.hoistFunctions(statements)
+
+	for i, stmt := range statements {
+		last := i == len(statements)-1
+		if es, ok := stmt.(*ast.ExpressionStatement); ok && last && wantValue {
+			c.compileExpression(es.Expression)
+			return
+		}
+		c.compileStatement(stmt)
+	}
+	if wantValue {
+		c.emit(code.OpNull)
+	}
+}
+
+// declare 在当前作用域中声明块中let和fn定义的变量，返回新分配的局部变量的编号范围
+func (c *Compiler) declare(statements []ast.Statement) (first, count int) {
+	first = len(c.symbolTable.LocalNames())
+	for _, stmt := range statements {
+		switch stmt := stmt.(type) {
+		case *ast.LetStatement:
+			c.symbolTable.Define(stmt.Name.Value)
+		case *ast.FunctionStatement:
+			c.symbolTable.Define(stmt.Name.Value)
+		}
+	}
+	return first, len(c.symbolTable.LocalNames()) - first
+}
+
+// hoistFunctions 在块的开头定义块中声明的所有函数，与求值器的hoistFunctions对应
+func (c *Compiler) hoistFunctions(statements []ast.Statement) {
+	var hoisted []*ast.FunctionStatement
+	for _, stmt := range statements {
+		if fs, ok := stmt.(*ast.FunctionStatement); ok {
+			c.symbolTable.Define(fs.Name.Value).Defined = true
+			hoisted = append(hoisted, fs)
+		}
+	}
+
+	for _, fs := range hoisted {
+		saved := c.pos
+		c.pos = fs.Pos()
+		c.compileFunctionLiteral(fs.Function)
+		c.hoisted[fs] = c.symbolTable.DefineHidden()
+		c.emit(code.OpDup)
+		c.emit(code.OpSetLocal, c.hoisted[fs])
+		c.emitDefine(c.symbolTable.Define(fs.Name.Value))
+		c.pos = saved
+	}
+}
+
+func (c *Compiler) compileFunctionLiteral(fl *ast.FunctionLiteral) {
+	c.enterScope()
+	c.symbolTable = NewFunctionSymbolTable(c.symbolTable)
+
+	params := make([]string, len(fl.Parameters))
+	for i, p := range fl.Parameters {
+		params[i] = p.Value
+		c.symbolTable.Define(p.Value).Defined = true
+	}
+	if len(params) > math.MaxUint8 {
+		c.errorf("too many parameters: %d", len(params))
+	}
+
+	c.compileBlock(fl.Body.Statements, true, false)
+	c.emit(code.OpReturnValue)
+
+	symbols := c.symbolTable
+	c.symbolTable = symbols.Outer
+	fn := c.newFunction(fl.Name, params, c.leaveScope(), symbols)
+	free := symbols.FreeSymbols()
+
+	for _, sym := range free {
+		c.emitLoad(sym)
+	}
+	c.emit(code.OpClosure, c.addConstant(fn), len(free))
+}
+
+// newFunction 用刚刚编译完成的函数的指令创建CompiledFunction，symbols是函数的作用域
+func (c *Compiler) newFunction(name string, params []string, scope *compilationScope, symbols *SymbolTable) *object.CompiledFunction {
+	localNames := symbols.LocalNames()
+	if len(localNames) > math.MaxUint16+1 {
+		c.errorf("too many local variables")
+	}
+	free := symbols.FreeSymbols()
+	freeNames := make([]string, len(free))
+	for i, sym := range free {
+		freeNames[i] = sym.Name
+	}
+
+	fn := &object.CompiledFunction{
+		Instructions:  scope.instructions,
+		NumLocals:     len(localNames),
+		NumParameters: len(params),
+		Name:          name,
+		Parameters:    params,
+		LocalNames:    localNames,
+		FreeNames:     freeNames,
+		Positions:     scope.positions,
+	}
+	c.functions = append(c.functions, fn)
+	return fn
+}
+
+func (c *Compiler) compileWhileExpression(exp *ast.WhileExpression) {
+	loop := c.enterLoop(exp.Label)
+
+	start := c.offset()
+	c.compileExpression(exp.Condition)
+	exit := c.emit(code.OpJumpNotTruthy, 9999)
+
+	c.enterBlock()
+	c.compileBlock(exp.Body.Statements, false, true)
+	c.leaveBlock()
+	c.emit(code.OpJump, start)
+
+	c.changeOperand(exit, c.offset())
+	c.leaveLoop(loop, start)
+}
+
+func (c *Compiler) compileForExpression(exp *ast.ForExpression) {
+	c.enterBlock() //循环头部声明的变量只在循环内可见
+	var header []ast.Statement
+	if exp.Initialize != nil {
+		header = append(header, exp.Initialize)
+	}
+	if exp.Cycleop != nil {
+		header = append(header, exp.Cycleop)
+	}
+	if first, count := c.declare(header); count > 0 {
+		c.emit(code.OpClearLocals, first, count)
+	}
+	if exp.Initialize != nil {
+		c.compileStatement(exp.Initialize)
+	}
+
+	loop := c.enterLoop(exp.Label)
+
+	start := c.offset()
+	c.compileExpression(exp.Condition)
+	exit := c.emit(code.OpJumpNotTruthy, 9999)
+
+	c.enterBlock()
+	c.compileBlock(exp.Body.Statements, false, true)
+	c.leaveBlock()
+
+	next := c.offset() //continue之后同样需要执行循环操作
+	if exp.Cycleop != nil {
+		c.compileStatement(exp.Cycleop)
+	}
+	c.emit(code.OpJump, start)
+
+	c.changeOperand(exit, c.offset())
+	c.leaveLoop(loop, next)
+	c.leaveBlock()
+}
+
+// compileForInExpression 迭代器保存在隐藏的局部变量中，
+// 循环变量在单独的块中，每次循环之前清空，闭包捕获的是当次的值
+func (c *Compiler) compileForInExpression(exp *ast.ForInExpression) {
+	c.compileExpression(exp.Iterable)
+	c.emit(code.OpIterator)
+	iter := c.symbolTable.DefineHidden()
+	c.emit(code.OpSetLocal, iter)
+
+	loop := c.enterLoop(exp.Label)
+
+	start := c.offset()
+	next := c.emit(code.OpIterNext, 9999, iter)
+
+	c.enterBlock()
+	first := len(c.symbolTable.LocalNames())
+	value := c.symbolTable.Define(exp.Value.Value)
+	var key *Symbol
+	if exp.Key != nil {
+		key = c.symbolTable.Define(exp.Key.Value)
+	}
+	c.emit(code.OpClearLocals, first, len(c.symbolTable.LocalNames())-first)
+	value.Defined = true
+	c.emitAssign(value)
+	if key != nil {
+		key.Defined = true
+		c.emitAssign(key)
+	} else {
+		c.emit(code.OpPop)
+	}
+
+	c.enterBlock()
+	c.compileBlock(exp.Body.Statements, false, true)
+	c.leaveBlock()
+	c.leaveBlock()
+	c.emit(code.OpJump, start)
+
+	c.changeOperand(next, c.offset())
+	c.leaveLoop(loop, start)
+}
+
+// enterLoop 开始编译一个循环，循环开始时记录栈的高度，break和continue跳转之前恢复
+func (c *Compiler) enterLoop(label *ast.Identifier) *control {
+	loop := &control{loop: true, mark: c.symbolTable.DefineHidden()}
+	if label != nil {
+		loop.label = label.Value
+	}
+	c.emit(code.OpMark, loop.mark)
+
+	scope := c.currentScope()
+	scope.controls = append(scope.controls, loop)
+	return loop
+}
+
+// leaveLoop 循环的代码编译完成，回填break和continue的跳转，循环的值为NULL
+func (c *Compiler) leaveLoop(loop *control, next int) {
+	scope := c.currentScope()
+	scope.controls = scope.controls[:len(scope.controls)-1]
+
+	for _, pos := range loop.continues {
+		c.changeOperand(pos, next)
+	}
+	for _, pos := range loop.breaks {
+		c.changeOperand(pos, c.offset())
+	}
+	c.emit(code.OpNull)
+}
+
+// compileLoopJump 编译break(isBreak为true)或continue，目标是标签为label的循环或者最内层的循环
+func (c *Compiler) compileLoopJump(label *ast.Identifier, isBreak bool) {
+	controls := c.currentScope().controls
+	for i := len(controls) - 1; i >= 0; i-- {
+		ctl := controls[i]
+		if !ctl.loop {
+			c.leaveControl(i)
+			continue
+		}
+		if label != nil && ctl.label != label.Value {
+			continue
+		}
+
+		c.emit(code.OpUnwind, ctl.mark)
+		jump := c.emit(code.OpJump, 9999)
+		if isBreak {
+			ctl.breaks = append(ctl.breaks, jump)
+		} else {
+			ctl.continues = append(ctl.continues, jump)
+		}
+		return
+	}
+	c.errorf("break or continue outside loop") //语法分析时已经检查过
+}
+
+// leaveControls 离开当前函数中从内到外直到第downTo层的所有try，用于return
+func (c *Compiler) leaveControls(downTo int) {
+	controls := c.currentScope().controls
+	for i := len(controls) - 1; i >= downTo; i-- {
+		if !controls[i].loop {
+			c.leaveControl(i)
+		}
+	}
+}
+
+// leaveControl 离开第i层的try：移除错误处理并执行finally。
+// finally中的break、continue和return不会再次执行这个finally
+func (c *Compiler) leaveControl(i int) {
+	scope := c.currentScope()
+	ctl := scope.controls[i]
+	c.emit(code.OpPopTry)
+	if ctl.finally == nil {
+		return
+	}
+
+	saved := scope.controls
+	scope.controls = saved[:i:i]
+	c.compileFinally(ctl.finally)
+	scope.controls = saved
+}
+
+// compileTryExpression try的值是try块的值，发生错误时是catch块的值。
+// finally的代码在每个离开try的地方各生成一份：正常结束、catch结束、没有被捕获的错误，
+// 以及try和catch中的break、continue和return
+func (c *Compiler) compileTryExpression(exp *ast.TryExpression) {
+	var exits []int
+
+	setup := c.emit(code.OpSetupTry, 9999)
+	c.pushTry(exp.Finally)
+	c.compileScopedBlock(exp.Body)
+	c.popTry()
+	c.emit(code.OpPopTry)
+	if exp.Finally != nil {
+		c.compileFinally(exp.Finally)
+	}
+	exits = append(exits, c.emit(code.OpJump, 9999))
+
+	c.changeOperand(setup, c.offset()) //发生错误时栈顶是*object.Error
+	if exp.Catch != nil {
+		c.enterBlock()
+		param := c.symbolTable.Define(exp.Param.Value)
+		param.Defined = true
+		_, count := c.declare(exp.Catch.Statements)
+		c.emit(code.OpClearLocals, param.Index, count+1) //catch的参数和catch中声明的变量在同一个作用域
+		c.emit(code.OpErrorValue)
+		c.emitDefine(param)
+
+		var setupFinally int
+		if exp.Finally != nil { //catch中的错误同样要先执行finally
+			setupFinally = c.emit(code.OpSetupTry, 9999)
+			c.pushTry(exp.Finally)
+		}
+		c.compileBlock(exp.Catch.Statements, true, false)
+		c.leaveBlock()
+
+		if exp.Finally == nil {
+			c.changeOperands(exits, c.offset())
+			return
+		}
+		c.popTry()
+		c.emit(code.OpPopTry)
+		c.compileFinally(exp.Finally)
+		exits = append(exits, c.emit(code.OpJump, 9999))
+		c.changeOperand(setupFinally, c.offset())
+	}
+
+	c.compileFinally(exp.Finally) //没有被捕获的错误在执行finally之后重新抛出
+	c.emit(code.OpRethrow)
+	c.changeOperands(exits, c.offset())
+}
+
+func (c *Compiler) pushTry(finally *ast.BlockStatement) {
+	scope := c.currentScope()
+	scope.controls = append(scope.controls, &control{finally: finally})
+}
+
+func (c *Compiler) popTry() {
+	scope := c.currentScope()
+	scope.controls = scope.controls[:len(scope.controls)-1]
+}
+
+// compileFinally finally的值被丢弃
+func (c *Compiler) compileFinally(block *ast.BlockStatement) {
+	c.enterBlock()
+	c.compileBlock(block.Statements, false, true)
+	c.leaveBlock()
+}
+
+func (c *Compiler) enterBlock() {
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveBlock() {
+	c.symbolTable = c.symbolTable.Outer
+}
+
+func (c *Compiler) emitGet(sym *Symbol) {
+	switch sym.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, c.addName(sym.Name))
+	case LocalScope:
+		c.emitLocal(code.OpGetLocal, sym)
+	case FreeScope:
+		c.emit(code.OpGetFree, sym.Index)
+	}
+}
+
+// emitDefine 定义变量，全局变量不存在时创建
+func (c *Compiler) emitDefine(sym *Symbol) {
+	if sym.Scope == GlobalScope {
+		c.emit(code.OpDefineGlobal, c.addName(sym.Name))
+		return
+	}
+	c.emitAssign(sym)
+}
+
+// emitAssign 给已经定义的变量赋值，变量不存在时是运行时错误
+func (c *Compiler) emitAssign(sym *Symbol) {
+	switch sym.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, c.addName(sym.Name))
+	case LocalScope:
+		c.emitLocal(code.OpSetLocal, sym)
+	case FreeScope:
+		c.emit(code.OpSetFree, sym.Index)
+	}
+}
+
+// emitLoad 把变量所在的Cell压入栈，用于创建闭包
+func (c *Compiler) emitLoad(sym *Symbol) {
+	switch sym.Scope {
+	case LocalScope:
+		c.emit(code.OpLoadCell, sym.Index)
+	case FreeScope:
+		c.emit(code.OpLoadFree, sym.Index)
+	}
+}
+
+func (c *Compiler) emitLocal(op code.Opcode, sym *Symbol) {
+	pos := c.emit(op, sym.Index)
+	scope := c.currentScope()
+	scope.localSites = append(scope.localSites, localSite{offset: pos, symbol: sym})
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	if len(c.constants) > math.MaxUint16+1 {
+		c.errorf("too many constants")
+	}
+	return len(c.constants) - 1
+}
+
+// addName 返回变量名在常量池中的下标，同一个名字只保存一次
+func (c *Compiler) addName(name string) int {
+	if i, ok := c.names[name]; ok {
+		return i
+	}
+	i := c.addConstant(&object.String{Value: name})
+	c.names[name] = i
+	return i
+}
+
+func (c *Compiler) currentScope() *compilationScope {
+	return c.scopes[len(c.scopes)-1]
+}
+
+func (c *Compiler) offset() int {
+	return len(c.currentScope().instructions)
+}
+
+// emit 生成一条指令，返回它的位置
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	for _, operand := range operands {
+		if operand > math.MaxUint16 {
+			c.errorf("operand %d out of range", operand)
+		}
+	}
+
+	scope := c.currentScope()
+	pos := len(scope.instructions)
+	if n := len(scope.positions); n == 0 || scope.positions[n-1].Pos != c.pos {
+		scope.positions = append(scope.positions, object.SourcePos{Offset: pos, Pos: c.pos})
+	}
+	scope.instructions = append(scope.instructions, code.Make(op, operands...)...)
+	return pos
+}
+
+// changeOperand 回填跳转指令的目标，目标是指令的第一个操作数
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	if operand > math.MaxUint16 {
+		c.errorf("function too large")
+	}
+	ins := c.currentScope().instructions
+	def, _ := code.Lookup(ins[opPos])
+	operands, _ := code.ReadOperands(def, ins[opPos+1:]) //其余的操作数保持不变
+	operands[0] = operand
+	copy(ins[opPos:], code.Make(code.Opcode(ins[opPos]), operands...))
+}
+
+func (c *Compiler) changeOperands(positions []int, operand int) {
+	for _, pos := range positions {
+		c.changeOperand(pos, operand)
+	}
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, &compilationScope{})
+}
+
+// leaveScope 结束一个函数的编译，把被闭包引用的局部变量的读写改为通过Cell进行
+func (c *Compiler) leaveScope() *compilationScope {
+	scope := c.currentScope()
+	c.scopes = c.scopes[:len(c.scopes)-1]
+
+	for _, site := range scope.localSites {
+		if !site.symbol.Captured {
+			continue
+		}
+		switch code.Opcode(scope.instructions[site.offset]) {
+		case code.OpGetLocal:
+			scope.instructions[site.offset] = byte(code.OpGetCell)
+		case code.OpSetLocal:
+			scope.instructions[site.offset] = byte(code.OpSetCell)
+		}
+	}
+	return scope
+}
@@ -0,0 +1,269 @@
+package compiler
+
+import (
+	"testing"
+
+	"my.com/myfile/code"
+	"my.com/myfile/lexer"
+	"my.com/myfile/object"
+	"my.com/myfile/parser"
+)
+
+type compilerTestCase struct {
+	input                string
+	expectedConstants    []string //常量的Inspect
+	expectedInstructions []code.Instructions
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	runCompilerTests(t, []compilerTestCase{
+		{
+			input:             "1 + 2",
+			expectedConstants: []string{"1", "2"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             "1; -2",
+			expectedConstants: []string{"1", "2"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMinus),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	})
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	runCompilerTests(t, []compilerTestCase{
+		{
+			input:             "let one = 1; one = one + 1",
+			expectedConstants: []string{"1", "one", "1"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpDefineGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpAdd),
+				code.Make(code.OpDup),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpReturnValue),
+			},
+		},
+		{
+			input:             "let x = 1;",
+			expectedConstants: []string{"1", "x"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpDefineGlobal, 1),
+				code.Make(code.OpReturnNil),
+			},
+		},
+	})
+}
+
+func TestConditionals(t *testing.T) {
+	runCompilerTests(t, []compilerTestCase{
+		{
+			input:             "if (true) { 10 }",
+			expectedConstants: []string{"10"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),              // 0000
+				code.Make(code.OpJumpNotTruthy, 10), // 0001
+				code.Make(code.OpConstant, 0),       // 0004
+				code.Make(code.OpJump, 11),          // 0007
+				code.Make(code.OpNull),              // 0010
+				code.Make(code.OpReturnValue),       // 0011
+			},
+		},
+		{
+			input:             "a && b",
+			expectedConstants: []string{"a", "b"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetGlobal, 0),     // 0000
+				code.Make(code.OpJumpFalsyKeep, 9), // 0003
+				code.Make(code.OpGetGlobal, 1),     // 0006
+				code.Make(code.OpReturnValue),      // 0009
+			},
+		},
+	})
+}
+
+func TestForIn(t *testing.T) {
+	runCompilerTests(t, []compilerTestCase{
+		{
+			input:             "for x in a { x }",
+			expectedConstants: []string{"a"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetGlobal, 0),      // 0000
+				code.Make(code.OpIterator),          // 0003
+				code.Make(code.OpSetLocal, 0),       // 0004 迭代器
+				code.Make(code.OpMark, 1),           // 0007
+				code.Make(code.OpIterNext, 31, 0),   // 0010
+				code.Make(code.OpClearLocals, 2, 1), // 0015
+				code.Make(code.OpSetLocal, 2),       // 0020 x
+				code.Make(code.OpPop),               // 0023 没有使用的键
+				code.Make(code.OpGetLocal, 2),       // 0024
+				code.Make(code.OpPop),               // 0027
+				code.Make(code.OpJump, 10),          // 0028
+				code.Make(code.OpNull),              // 0031
+				code.Make(code.OpReturnValue),       // 0032
+			},
+		},
+	})
+}
+
+func TestSlice(t *testing.T) {
+	runCompilerTests(t, []compilerTestCase{
+		{
+			input:             "a[:1]",
+			expectedConstants: []string{"a", "1"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpNull), //省略的下标
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSlice),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	})
+}
+
+func TestClosures(t *testing.T) {
+	runCompilerTests(t, []compilerTestCase{
+		{
+			input: "fn(a) { fn(b) { a + b } }",
+			expectedConstants: []string{
+				"CompiledFunction[fn(b)]",
+				"CompiledFunction[fn(a)]",
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	})
+
+	fns := compileFunctions(t, "fn(a) { let b = 1; fn() { a + b } }")
+	inner, outer := fns[0], fns[1]
+
+	assertInstructions(t, "inner", []code.Instructions{
+		code.Make(code.OpGetFree, 0),
+		code.Make(code.OpGetFree, 1),
+		code.Make(code.OpAdd),
+		code.Make(code.OpReturnValue),
+	}, inner.Instructions)
+
+	//被内层函数引用的参数和局部变量通过Cell读写
+	assertInstructions(t, "outer", []code.Instructions{
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpSetCell, 1),
+		code.Make(code.OpLoadCell, 0),
+		code.Make(code.OpLoadCell, 1),
+		code.Make(code.OpClosure, 1, 2),
+		code.Make(code.OpReturnValue),
+	}, outer.Instructions)
+
+	if outer.NumLocals != 2 || outer.NumParameters != 1 {
+		t.Errorf("wrong locals. NumLocals=%d, NumParameters=%d", outer.NumLocals, outer.NumParameters)
+	}
+	if len(inner.FreeNames) != 2 || inner.FreeNames[0] != "a" || inner.FreeNames[1] != "b" {
+		t.Errorf("wrong free names. got=%v", inner.FreeNames)
+	}
+}
+
+func TestLetSeesOuterVariable(t *testing.T) {
+	//与求值器一样，let的值中的x是外层的x
+	fns := compileFunctions(t, "fn(x) { if (true) { let x = x + 1; x } }")
+	fn := fns[0]
+
+	assertInstructions(t, "fn", []code.Instructions{
+		code.Make(code.OpTrue),
+		code.Make(code.OpJumpNotTruthy, 25),
+		code.Make(code.OpClearLocals, 1, 1),
+		code.Make(code.OpGetLocal, 0),
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpAdd),
+		code.Make(code.OpSetLocal, 1),
+		code.Make(code.OpGetLocal, 1),
+		code.Make(code.OpJump, 26),
+		code.Make(code.OpNull),
+		code.Make(code.OpReturnValue),
+	}, fn.Instructions)
+}
+
+func TestCompilerErrors(t *testing.T) {
+	args := "0"
+	for i := 1; i < 256; i++ {
+		args += ", 0"
+	}
+
+	program := parser.New(lexer.New("f(" + args + ")")).ParseProgram()
+	c := New()
+	if err := c.Compile(program); err == nil || err.Error() != "1:2: too many arguments: 256" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
+
+func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		c := New()
+		if err := c.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := c.Bytecode()
+
+		assertInstructions(t, tt.input, tt.expectedInstructions, bytecode.Main.Instructions)
+
+		if len(bytecode.Constants) != len(tt.expectedConstants) {
+			t.Errorf("%q: wrong number of constants. want=%d, got=%d",
+				tt.input, len(tt.expectedConstants), len(bytecode.Constants))
+			continue
+		}
+		for i, want := range tt.expectedConstants {
+			if got := bytecode.Constants[i].Inspect(); got != want {
+				t.Errorf("%q: wrong constant %d. want=%q, got=%q", tt.input, i, want, got)
+			}
+		}
+	}
+}
+
+// compileFunctions 编译input，按常量池中的顺序返回所有的函数
+func compileFunctions(t *testing.T, input string) []*object.CompiledFunction {
+	t.Helper()
+
+	c := New()
+	if err := c.Compile(parser.New(lexer.New(input)).ParseProgram()); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var fns []*object.CompiledFunction
+	for _, constant := range c.Bytecode().Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			fns = append(fns, fn)
+		}
+	}
+	return fns
+}
+
+func assertInstructions(t *testing.T, name string, expected []code.Instructions, actual code.Instructions) {
+	t.Helper()
+
+	var want code.Instructions
+	for _, ins := range expected {
+		want = append(want, ins...)
+	}
+	if want.String() != actual.String() {
+		t.Errorf("%s: wrong instructions.\nwant=\n%s\ngot=\n%s", name, want, actual)
+	}
+}
@@ -0,0 +1,131 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope SymbolScope = "GLOBAL" //顶层的变量，按名字保存在object.Environment中
+	LocalScope  SymbolScope = "LOCAL"  //保存在栈帧中的变量
+	FreeScope   SymbolScope = "FREE"   //闭包引用的外层函数的变量
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int //局部变量在栈帧中的位置或者自由变量的编号，全局变量没有编号
+
+	// Defined 局部变量的let是否已经编译过。与求值器一样，
+	// 同一个函数中在let之前引用变量得到的是外层的同名变量，而函数中的函数可以引用之后才定义的变量
+	Defined bool
+	// Captured 局部变量被内层的函数引用，需要保存在Cell中
+	Captured bool
+}
+
+// SymbolTable 一个作用域中的变量。函数体、if的分支、循环体、try和catch都是单独的作用域，
+// 同一个函数中的所有作用域共享局部变量的编号，所以每个变量在栈帧中有固定的位置
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store  map[string]*Symbol
+	fn     *functionSymbols //所属的函数，顶层作用域属于最外层的程序
+	global bool
+}
+
+// functionSymbols 一个函数中的局部变量和自由变量
+type functionSymbols struct {
+	localNames []string //局部变量名，下标就是变量的编号
+	free       []*Symbol
+	freeOf     map[*Symbol]*Symbol //外层的符号对应的自由变量
+}
+
+// NewSymbolTable 创建顶层作用域，顶层之外的变量都是全局变量
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{store: make(map[string]*Symbol), fn: newFunctionSymbols(), global: true}
+}
+
+// NewFunctionSymbolTable 创建函数的作用域，函数的参数和函数体中直接声明的变量都在这个作用域中
+func NewFunctionSymbolTable(outer *SymbolTable) *SymbolTable {
+	return &SymbolTable{Outer: outer, store: make(map[string]*Symbol), fn: newFunctionSymbols()}
+}
+
+// NewBlockSymbolTable 创建块作用域，与outer属于同一个函数
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	return &SymbolTable{Outer: outer, store: make(map[string]*Symbol), fn: outer.fn}
+}
+
+func newFunctionSymbols() *functionSymbols {
+	return &functionSymbols{freeOf: make(map[*Symbol]*Symbol)}
+}
+
+// Define 在当前作用域中声明name，已经声明过时返回原来的符号
+func (s *SymbolTable) Define(name string) *Symbol {
+	if sym, ok := s.store[name]; ok {
+		return sym
+	}
+
+	var sym *Symbol
+	if s.global {
+		sym = &Symbol{Name: name, Scope: GlobalScope, Defined: true}
+	} else {
+		sym = &Symbol{Name: name, Scope: LocalScope, Index: s.fn.allocate(name)}
+	}
+	s.store[name] = sym
+	return sym
+}
+
+// DefineHidden 分配一个脚本中无法访问的局部变量，返回它的编号
+func (s *SymbolTable) DefineHidden() int {
+	return s.fn.allocate("")
+}
+
+func (f *functionSymbols) allocate(name string) int {
+	f.localNames = append(f.localNames, name)
+	return len(f.localNames) - 1
+}
+
+// Resolve 查找name对应的符号，局部变量和自由变量中都找不到时是全局变量
+func (s *SymbolTable) Resolve(name string) *Symbol {
+	return s.resolve(name, true)
+}
+
+// sameFunction为false时是在为内层的函数查找，这时还没有执行到let的变量也可以使用
+func (s *SymbolTable) resolve(name string, sameFunction bool) *Symbol {
+	if s.global {
+		return s.Define(name)
+	}
+	if sym, ok := s.store[name]; ok && (sym.Defined || !sameFunction) {
+		return sym
+	}
+	if s.Outer.fn == s.fn {
+		return s.Outer.resolve(name, sameFunction)
+	}
+
+	sym := s.Outer.resolve(name, false)
+	if sym.Scope == GlobalScope {
+		return sym
+	}
+	return s.fn.defineFree(sym)
+}
+
+func (f *functionSymbols) defineFree(original *Symbol) *Symbol {
+	if sym, ok := f.freeOf[original]; ok {
+		return sym
+	}
+	if original.Scope == LocalScope {
+		original.Captured = true
+	}
+
+	sym := &Symbol{Name: original.Name, Scope: FreeScope, Index: len(f.free), Defined: true}
+	f.free = append(f.free, original)
+	f.freeOf[original] = sym
+	return sym
+}
+
+// FreeSymbols 返回函数引用的外层符号，按自由变量的编号排列，创建闭包时按这个顺序取出它们
+func (s *SymbolTable) FreeSymbols() []*Symbol {
+	return s.fn.free
+}
+
+// LocalNames 返回函数中所有局部变量的名字，下标是变量的编号
+func (s *SymbolTable) LocalNames() []string {
+	return s.fn.localNames
+}
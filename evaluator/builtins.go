@@ -2,41 +2,45 @@ package evaluator
 
 import (
 	"fmt"
+	"sort"
+	"unicode/utf8"
+
 	"my.com/myfile/object"
 )
 
 var builtins = map[string]*object.Builtin{
 	"len": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d,want=1")
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1",
+					len(args))
 			}
 
 			switch arg := args[0].(type) {
 			case *object.String:
-				return &object.Integer{Value: int64(len(arg.Value))}
+				return &object.Integer{Value: int64(utf8.RuneCountInString(arg.Value))} //按字符计数，与下标和切片一致
 			default:
-				return newError("arguments to `len` not supported, got  %s", args[0].Type())
+				return newError(object.TYPE_ERROR, "arguments to `len` not supported, got  %s", args[0].Type())
 			}
 		},
 	},
 	"puts": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			for _, arg := range args {
-				fmt.Print(arg.Inspect())
+				fmt.Fprint(rt.Stdout, arg.Inspect())
 			}
 
 			return nil
 		},
 	},
 	"first": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1",
 					len(args))
 			}
 			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `first` must be ARRAY, got %s",
+				return newError(object.TYPE_ERROR, "argument to `first` must be ARRAY, got %s",
 					args[0].Type())
 			}
 			arr := args[0].(*object.Array)
@@ -47,13 +51,13 @@ var builtins = map[string]*object.Builtin{
 		},
 	},
 	"last": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1",
 					len(args))
 			}
 			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `last` must be ARRAY, got %s",
+				return newError(object.TYPE_ERROR, "argument to `last` must be ARRAY, got %s",
 					args[0].Type())
 			}
 			arr := args[0].(*object.Array)
@@ -65,13 +69,13 @@ var builtins = map[string]*object.Builtin{
 		},
 	},
 	"rest": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=1",
 					len(args))
 			}
 			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `rest` must be ARRAY, got %s",
+				return newError(object.TYPE_ERROR, "argument to `rest` must be ARRAY, got %s",
 					args[0].Type())
 			}
 			arr := args[0].(*object.Array)
@@ -85,13 +89,13 @@ var builtins = map[string]*object.Builtin{
 		},
 	},
 	"push": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			if len(args) != 2 {
-				return newError("wrong number of arguments. got=%d, want=2",
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=2",
 					len(args))
 			}
 			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `push` must be ARRAY, got %s",
+				return newError(object.TYPE_ERROR, "argument to `push` must be ARRAY, got %s",
 					args[0].Type())
 			}
 			arr := args[0].(*object.Array)
@@ -102,16 +106,39 @@ var builtins = map[string]*object.Builtin{
 			return &object.Array{Elements: newElements}
 		},
 	},
+	"step": &object.Builtin{
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=2",
+					len(args))
+			}
+			r, ok := args[0].(*object.Range)
+			if !ok {
+				return newError(object.TYPE_ERROR, "first argument to `step` must be RANGE, got %s",
+					args[0].Type())
+			}
+			n, ok := args[1].(*object.Integer)
+			if !ok {
+				return newError(object.TYPE_ERROR, "second argument to `step` must be INTEGER, got %s",
+					args[1].Type())
+			}
+			if n.Value == 0 {
+				return newError(object.ARGUMENT_ERROR, "step must not be zero")
+			}
+			// 返回新的区间，原来的区间不变
+			return &object.Range{Start: r.Start, End: r.End, Step: n.Value, Inclusive: r.Inclusive}
+		},
+	},
 	"length": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
 			// 只接受一个参赛，即要统计的数组
 			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=2",
+				return newError(object.ARGUMENT_ERROR, "wrong number of arguments. got=%d, want=2",
 					len(args))
 			}
 			// 只能对数组使用
 			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `push` must be ARRAY, got %s",
+				return newError(object.TYPE_ERROR, "argument to `push` must be ARRAY, got %s",
 					args[0].Type())
 			}
 			arr := args[0].(*object.Array)
@@ -119,3 +146,13 @@ var builtins = map[string]*object.Builtin{
 		},
 	},
 }
+
+// BuiltinNames 返回所有内置函数的名字，按字母顺序排列，用于REPL的Tab补全
+func BuiltinNames() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
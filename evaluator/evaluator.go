@@ -2,6 +2,8 @@ package evaluator
 
 import (
 	"fmt"
+	"math"
+	"strings"
 
 	"my.com/myfile/ast"
 	"my.com/myfile/object"
@@ -13,7 +15,48 @@ var (
 	FALSE = &object.Boolean{Value: false}
 )
 
-func Eval(node ast.Node, env *object.Environment) object.Object { //repl调用的函数
+// Eval 对node求值，是repl调用的函数。
+// 求值过程中发生的Go panic会被转换为*object.Error，不会使宿主程序崩溃。
+// 求值受env的Runtime中的Context和Limits限制
+func Eval(node ast.Node, env *object.Environment) (result object.Object) {
+	defer env.Runtime().Begin()()
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError(object.INTERNAL_ERROR, "internal error: %v", r)
+		}
+	}()
+
+	return eval(node, env)
+}
+
+// Apply 用args调用函数fn，fn可以是Wizard函数或内置函数，供嵌入解释器的Go程序调用。
+// 与Eval一样，panic会被转换为*object.Error
+func Apply(fn object.Object, args []object.Object, rt *object.Runtime) (result object.Object) {
+	defer rt.Begin()()
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError(object.INTERNAL_ERROR, "internal error: %v", r)
+		}
+	}()
+
+	return applyFunction(fn, args, rt, nil)
+}
+
+// eval 是递归求值的入口，所有内部的求值都通过它进行
+func eval(node ast.Node, env *object.Environment) object.Object {
+	var result object.Object
+	if err := env.Runtime().Step(); err != nil { //超过执行限制或者被取消
+		result = newError(object.LIMIT_ERROR, "%s", err)
+	} else {
+		result = evalNode(node, env)
+	}
+	if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = node.Pos() //错误第一次返回时记录产生它的节点的位置
+	}
+	return result
+}
+
+func evalNode(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) { //观察这个switch语句，尽管对于不同的ast结构体有不同的处理函数，但是实际上都会返回一个接口
 
 	// Statements
@@ -24,73 +67,104 @@ func Eval(node ast.Node, env *object.Environment) object.Object { //repl调用
 		return evalBlockStatement(node, env)
 
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env)
+		return eval(node.Expression, env)
 
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
+		val := eval(node.ReturnValue, env)
 		if isError(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 
+	case *ast.ThrowStatement:
+		val := eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		return throwValue(val)
+
 	case *ast.BreakStatement:
-		return &object.BreakValue{Value: NULL}
+		if node.Label != nil {
+			return &object.BreakValue{Label: node.Label.Value}
+		}
+		return &object.BreakValue{}
 
 	case *ast.ContinueStatement:
-		return &object.ContinueValue{Value: NULL}
+		if node.Label != nil {
+			return &object.ContinueValue{Label: node.Label.Value}
+		}
+		return &object.ContinueValue{}
 
 	case *ast.LetStatement:
-		val := Eval(node.Value, env)
+		val := eval(node.Value, env)
 		if isError(val) {
 			return val
 		}
+		if val == nil { //例如 let a = if (true) {}
+			val = NULL
+		}
 		env.Set(node.Name.Value, val)
 
 	// 表达式
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
 
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
+
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, env)
+		right := eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
 		return evalPrefixExpression(node.Operator, right)
 
 	case *ast.InfixExpression:
-		left := Eval(node.Left, env)
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
+
+		left := eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
 
-		right := Eval(node.Right, env)
+		right := eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
 
 		return evalInfixExpression(node.Operator, left, right)
 
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
+
 	// 控制语句
 	case *ast.IfExpression:
 		return evalIfExpression(node, env) //
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
 	case *ast.ForExpression:
 		return evalForExpression(node, env)
 	case *ast.WhileExpression:
 		return evalWhileExpression(node, env)
+	case *ast.ForInExpression:
+		return evalForInExpression(node, env)
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 
 	case *ast.FunctionLiteral:
-		params := node.Parameters
-		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return newFunction(node, env)
+
+	case *ast.FunctionStatement: //函数已经在块开始时定义，这里重新绑定是为了单独求值这个语句时也能定义函数
+		env.Set(node.Name.Value, newFunction(node.Function, env))
 
 		// 表达式处理
 	case *ast.CallExpression:
-		function := Eval(node.Function, env)
+		function := eval(node.Function, env)
 		if isError(function) {
 			return function
 		}
@@ -100,7 +174,11 @@ func Eval(node ast.Node, env *object.Environment) object.Object { //repl调用
 			return args[0]
 		}
 
-		return applyFunction(function, args)
+		result := applyFunction(function, args, env.Runtime(), node)
+		if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+			err.Pos = node.Function.Pos() //调用本身产生的错误与调用栈一样指向被调用的表达式
+		}
+		return result
 
 		// 字符串求值
 	case *ast.StringLiteral:
@@ -116,16 +194,19 @@ func Eval(node ast.Node, env *object.Environment) object.Object { //repl调用
 
 	// 处理下标读取
 	case *ast.IndexExpression:
-		left := Eval(node.Left, env)
+		left := eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
-		index := Eval(node.Index, env)
+		index := eval(node.Index, env)
 		if isError(index) {
 			return index
 		}
 		return evalIndexExpression(left, index)
 
+	case *ast.SliceExpression:
+		return evalSliceExpression(node, env)
+
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
 	}
@@ -135,15 +216,18 @@ func Eval(node ast.Node, env *object.Environment) object.Object { //repl调用
 
 func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	var result object.Object
+	hoistFunctions(program.Statements, env)
 
 	for _, statement := range program.Statements { //通过循环遍历所有语句，对每个语句调用Eval函数
-		result = Eval(statement, env)
+		result = eval(statement, env)
 
 		switch result := result.(type) {
 		case *object.ReturnValue:
 			return result.Value //如果遇到了Return类型，则提早返回这个值
 		case *object.Error:
 			return result //异常处理
+		case *object.BreakValue, *object.ContinueValue:
+			return newError(object.SYNTAX_ERROR, "%s outside loop", result.Inspect())
 		}
 	}
 
@@ -155,13 +239,15 @@ func evalBlockStatement(
 	env *object.Environment,
 ) object.Object { //处理块
 	var result object.Object
+	hoistFunctions(block.Statements, env)
 
 	for _, statement := range block.Statements {
-		result = Eval(statement, env) //对每个语句求值
+		result = eval(statement, env) //对每个语句求值
 
 		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			switch result.Type() { //return，错误，break和continue都会结束当前的块
+			case object.RETURN_VALUE_OBJ, object.ERROR_OBJ,
+				object.BREAK_VALUE_OBJ, object.CONTINUE_VALUE_OBJ:
 				return result
 			}
 		}
@@ -170,6 +256,20 @@ func evalBlockStatement(
 	return result //返回
 }
 
+// hoistFunctions 在执行块中的语句之前定义块中声明的所有函数，
+// 这样函数可以在声明之前调用，互相递归的函数也不需要考虑声明的顺序
+func hoistFunctions(statements []ast.Statement, env *object.Environment) {
+	for _, statement := range statements {
+		if fs, ok := statement.(*ast.FunctionStatement); ok {
+			env.Set(fs.Name.Value, newFunction(fs.Function, env))
+		}
+	}
+}
+
+func newFunction(fl *ast.FunctionLiteral, env *object.Environment) *object.Function {
+	return &object.Function{Name: fl.Name, Parameters: fl.Parameters, Env: env, Body: fl.Body}
+}
+
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	if input {
 		return TRUE
@@ -183,8 +283,10 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 		return evalBangOperatorExpression(right)
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
+	case "~":
+		return evalBitNotPrefixOperatorExpression(right)
 	default:
-		return newError("unknown operator: %s%s", operator, right.Type())
+		return newError(object.TYPE_ERROR, "unknown operator: %s%s", operator, right.Type())
 	}
 }
 
@@ -195,6 +297,8 @@ func evalInfixExpression(
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right): //至少有一个是浮点数，整数会被转换为浮点数
+		return evalFloatInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
 	case operator == "==":
@@ -202,14 +306,35 @@ func evalInfixExpression(
 	case operator == "!=":
 		return nativeBoolToBooleanObject(left != right)
 	case left.Type() != right.Type():
-		return newError("type mismatch: %s %s %s",
+		return newError(object.TYPE_ERROR, "type mismatch: %s %s %s",
 			left.Type(), operator, right.Type())
 	default:
-		return newError("unknown operator: %s %s %s",
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
 	}
 }
 
+// evalLogicalExpression 短路求值：左边已经能决定结果时不再计算右边，
+// 结果是决定结果的那个操作数本身，而不是转换后的布尔值
+func evalLogicalExpression(
+	node *ast.InfixExpression,
+	env *object.Environment,
+) object.Object {
+	left := eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	if node.Operator == "&&" && !isTruthy(left) {
+		return left
+	}
+	if node.Operator == "||" && isTruthy(left) {
+		return left
+	}
+
+	return eval(node.Right, env)
+}
+
 func evalBangOperatorExpression(right object.Object) object.Object {
 	switch right {
 	case TRUE:
@@ -224,15 +349,26 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: -right.Value}
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
+		return newError(object.TYPE_ERROR, "unknown operator: -%s", right.Type())
+	}
+}
+
+func evalBitNotPrefixOperatorExpression(right object.Object) object.Object {
 	if right.Type() != object.INTEGER_OBJ {
-		return newError("unknown operator: -%s", right.Type())
+		return newError(object.TYPE_ERROR, "unknown operator: ~%s", right.Type())
 	}
 
 	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+	return &object.Integer{Value: ^value}
 }
 
-func evalStringInfixExpression(//字符串比较
+func evalStringInfixExpression( //字符串比较
 	operator string,
 	left, right object.Object,
 ) object.Object {
@@ -254,7 +390,7 @@ func evalStringInfixExpression(//字符串比较
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return newError("unknown operator: %s %s %s",
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
 	}
 }
@@ -273,8 +409,44 @@ func evalIntegerInfixExpression(
 		return &object.Integer{Value: leftVal - rightVal}
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
+	case "..":
+		return &object.Range{Start: leftVal, End: rightVal, Step: 1}
+	case "..=":
+		return &object.Range{Start: leftVal, End: rightVal, Step: 1, Inclusive: true}
 	case "/":
+		if rightVal == 0 {
+			return newError(object.ZERO_DIVISION_ERROR, "division by zero: %d / 0", leftVal)
+		}
+		if leftVal == math.MinInt64 && rightVal == -1 {
+			return newError(object.ARITHMETIC_ERROR, "integer overflow: %d / -1", leftVal)
+		}
 		return &object.Integer{Value: leftVal / rightVal}
+	case "%":
+		if rightVal == 0 {
+			return newError(object.ZERO_DIVISION_ERROR, "division by zero: %d %% 0", leftVal)
+		}
+		return &object.Integer{Value: leftVal % rightVal}
+	case "**":
+		if rightVal < 0 { //负指数的结果是小数
+			return &object.Float{Value: math.Pow(float64(leftVal), float64(rightVal))}
+		}
+		return &object.Integer{Value: intPow(leftVal, rightVal)}
+	case "&":
+		return &object.Integer{Value: leftVal & rightVal}
+	case "|":
+		return &object.Integer{Value: leftVal | rightVal}
+	case "^":
+		return &object.Integer{Value: leftVal ^ rightVal}
+	case "<<":
+		if rightVal < 0 {
+			return newError(object.ARITHMETIC_ERROR, "negative shift count: %d", rightVal)
+		}
+		return &object.Integer{Value: leftVal << rightVal}
+	case ">>":
+		if rightVal < 0 {
+			return newError(object.ARITHMETIC_ERROR, "negative shift count: %d", rightVal)
+		}
+		return &object.Integer{Value: leftVal >> rightVal}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
@@ -288,25 +460,208 @@ func evalIntegerInfixExpression(
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return newError("unknown operator: %s %s %s",
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s",
 			left.Type(), operator, right.Type())
 	}
 }
 
+func evalFloatInfixExpression(
+	operator string,
+	left, right object.Object,
+) object.Object {
+	leftVal := toFloat(left)
+	rightVal := toFloat(right)
+
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case "**":
+		return &object.Float{Value: math.Pow(leftVal, rightVal)}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError(object.TYPE_ERROR, "unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+}
+
+// intPow 用快速幂计算base的exp次方，exp不能为负数，溢出时与其他整数运算一样回绕
+func intPow(base, exp int64) int64 {
+	result := int64(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+	return result
+}
+
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+// toFloat 把整数或浮点数转换为float64
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.Float:
+		return obj.Value
+	}
+	return 0
+}
+
+// evalAssignExpression 更新已经声明的变量或者数组、哈希表的元素，复合赋值先取出旧值再计算
+func evalAssignExpression(
+	node *ast.AssignExpression,
+	env *object.Environment,
+) object.Object {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		return evalIdentifierAssignment(node, target, env)
+	case *ast.IndexExpression:
+		return evalIndexAssignment(node, target, env)
+	default:
+		return newError(object.SYNTAX_ERROR, "cannot assign to %s", node.Target.String())
+	}
+}
+
+func evalIdentifierAssignment(
+	node *ast.AssignExpression,
+	ident *ast.Identifier,
+	env *object.Environment,
+) object.Object {
+	var current object.Object
+	if node.Operator != "=" {
+		var ok bool
+		current, ok = env.Get(ident.Value)
+		if !ok {
+			return newError(object.NAME_ERROR, "identifier not found: " + ident.Value)
+		}
+	}
+
+	val := evalAssignedValue(node, current, env)
+	if isError(val) {
+		return val
+	}
+
+	if !env.Assign(ident.Value, val) {
+		return newError(object.NAME_ERROR, "assignment to undeclared variable: %s", ident.Value)
+	}
+	return val
+}
+
+// evalIndexAssignment 处理 arr[i] = v 和 hash[k] = v，直接修改原来的数组或哈希表
+func evalIndexAssignment(
+	node *ast.AssignExpression,
+	target *ast.IndexExpression,
+	env *object.Environment,
+) object.Object {
+	left := eval(target.Left, env)
+	if isError(left) {
+		return left
+	}
+	index := eval(target.Index, env)
+	if isError(index) {
+		return index
+	}
+
+	if err := checkIndexTarget(left, index); err != nil {
+		return err
+	}
+
+	var current object.Object
+	if node.Operator != "=" {
+		current = evalIndexExpression(left, index)
+	}
+
+	val := evalAssignedValue(node, current, env)
+	if isError(val) {
+		return val
+	}
+
+	setIndex(left, index, val)
+	return val
+}
+
+// setIndex 给left[index]赋值，left和index已经通过了checkIndexTarget的检查
+func setIndex(left, index, val object.Object) {
+	switch left := left.(type) {
+	case *object.Array:
+		idx, _ := arrayIndex(index.(*object.Integer).Value, len(left.Elements))
+		left.Elements[idx] = val
+	case *object.Hash:
+		left.Pairs[index.(object.Hashable).HashKey()] = object.HashPair{Key: index, Value: val}
+	}
+}
+
+// checkIndexTarget 检查left[index]能否被赋值
+func checkIndexTarget(left, index object.Object) *object.Error {
+	switch left := left.(type) {
+	case *object.Array:
+		idx, ok := index.(*object.Integer)
+		if !ok {
+			return newError(object.TYPE_ERROR, "array index must be INTEGER, got %s", index.Type())
+		}
+		if _, ok := arrayIndex(idx.Value, len(left.Elements)); !ok {
+			return newError(object.INDEX_ERROR, "index out of range: %d (length %d)", idx.Value, len(left.Elements))
+		}
+	case *object.Hash:
+		if _, ok := index.(object.Hashable); !ok {
+			return newError(object.TYPE_ERROR, "unusable as hash key: %s", index.Type())
+		}
+	default:
+		return newError(object.TYPE_ERROR, "index assignment not supported: %s", left.Type())
+	}
+	return nil
+}
+
+// evalAssignedValue 计算赋值号右边的值，复合赋值时与旧值current做运算
+func evalAssignedValue(
+	node *ast.AssignExpression,
+	current object.Object,
+	env *object.Environment,
+) object.Object {
+	val := eval(node.Value, env)
+	if isError(val) || node.Operator == "=" {
+		return val
+	}
+	return evalInfixExpression(strings.TrimSuffix(node.Operator, "="), current, val)
+}
 
 func evalIfExpression(
 	ie *ast.IfExpression,
 	env *object.Environment,
 ) object.Object {
-	condition := Eval(ie.Condition, env)
+	condition := eval(ie.Condition, env)
 	if isError(condition) {
 		return condition
 	}
 
-	if isTruthy(condition) {
-		return Eval(ie.Consequence, env)
+	if isTruthy(condition) { //if和else的块都有自己的作用域
+		return eval(ie.Consequence, object.NewEnclosedEnvironment(env))
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, env)
+		return eval(ie.Alternative, object.NewEnclosedEnvironment(env))
 	} else {
 		return NULL
 	}
@@ -323,7 +678,7 @@ func evalIdentifier(
 	if builtin, ok := builtins[node.Value]; ok {
 		return builtin
 	}
-	return newError("identifier not found: " + node.Value)
+	return newError(object.NAME_ERROR, "identifier not found: " + node.Value)
 }
 
 func isTruthy(obj object.Object) bool { //参数是Boolean，返回布尔值
@@ -339,8 +694,53 @@ func isTruthy(obj object.Object) bool { //参数是Boolean，返回布尔值
 	}
 }
 
-func newError(format string, a ...interface{}) *object.Error {
-	return &object.Error{Message: fmt.Sprintf(format, a...)}
+// throwValue 把throw的值转换成错误：字符串作为错误信息，
+// 捕获的错误重新抛出原来的错误，其他值用Inspect的结果作为错误信息
+func throwValue(val object.Object) *object.Error {
+	switch val := val.(type) {
+	case *object.ErrorValue: //重新抛出捕获的错误
+		//复制错误，再次经过的调用只记录在副本中，捕获的错误和之前抛出的副本都不受影响
+		err := *val.Err
+		err.Stack = make([]object.Frame, len(val.Err.Stack))
+		copy(err.Stack, val.Err.Stack)
+		return &err
+	case *object.String:
+		return &object.Error{Kind: object.ERROR_KIND, Message: val.Value, Value: val}
+	}
+	return &object.Error{Kind: object.ERROR_KIND, Message: val.Inspect(), Value: val}
+}
+
+// evalTryExpression try的值是try块的值，发生错误时是catch块的值。
+// finally块总是会执行，它产生的错误、return、break和continue会代替try和catch的结果
+func evalTryExpression(te *ast.TryExpression, env *object.Environment) object.Object {
+	result := eval(te.Body, object.NewEnclosedEnvironment(env))
+
+	if errObj, ok := result.(*object.Error); ok {
+		if !errObj.Catchable() { //超过执行限制时不再执行任何代码
+			return errObj
+		}
+		if te.Catch != nil {
+			catchEnv := object.NewEnclosedEnvironment(env)
+			catchEnv.Set(te.Param.Value, &object.ErrorValue{Err: errObj})
+			result = eval(te.Catch, catchEnv)
+		}
+	}
+
+	if te.Finally != nil {
+		finalResult := eval(te.Finally, object.NewEnclosedEnvironment(env))
+		if finalResult != nil {
+			switch finalResult.Type() {
+			case object.ERROR_OBJ, object.RETURN_VALUE_OBJ, object.BREAK_VALUE_OBJ, object.CONTINUE_VALUE_OBJ:
+				return finalResult
+			}
+		}
+	}
+
+	return result
+}
+
+func newError(kind string, format string, a ...interface{}) *object.Error {
+	return &object.Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
 }
 
 func isError(obj object.Object) bool {
@@ -357,30 +757,59 @@ func evalExpressions( //计算表达式，表达式可能有多个
 	var result []object.Object
 
 	for _, e := range exps {
-		evaluated := Eval(e, env)
+		evaluated := eval(e, env)
 		if isError(evaluated) {
 			return []object.Object{evaluated}
 		}
+		if evaluated == nil {
+			evaluated = NULL
+		}
 		result = append(result, evaluated)
 	}
 
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object { //如果遇到函数调用，则直接执行该函数，如果有返回值，则返回它
+// call是调用fn的表达式，用于在错误的调用栈中记录调用位置，从Go调用时为nil
+func applyFunction(fn object.Object, args []object.Object, rt *object.Runtime, call *ast.CallExpression) object.Object { //如果遇到函数调用，则直接执行该函数，如果有返回值，则返回它
 	switch fn := fn.(type) {
 
 	case *object.Function:
+		if len(args) != len(fn.Parameters) {
+			return newError(object.ARGUMENT_ERROR, "wrong number of arguments to %s: expected %d, got %d",
+				fn.DisplayName(), len(fn.Parameters), len(args))
+		}
+		if err := rt.EnterCall(); err != nil {
+			return newError(object.LIMIT_ERROR, "%s", err)
+		}
+		defer rt.ExitCall()
+
 		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
+		evaluated := eval(fn.Body, extendedEnv)
+		if errObj, ok := evaluated.(*object.Error); ok { //错误离开函数时记录这一层调用
+			errObj.Stack = append(errObj.Stack, newFrame(fn, call))
+		}
 		return unwrapReturnValue(evaluated)
 
 	case *object.Builtin:
-		return fn.Fn(args...)
+		result := fn.Fn(rt, args...)
+		if result == nil {
+			return NULL
+		}
+		return result
 
 	default:
-		return newError("not a function: %s", fn.Type())
+		return newError(object.TYPE_ERROR, "not a function: %s", fn.Type())
+	}
+}
+
+// newFrame 返回调用栈中的一层，从Go调用时call为nil，没有调用位置
+func newFrame(fn *object.Function, call *ast.CallExpression) object.Frame {
+	frame := object.Frame{Function: fn.DisplayName()}
+	if call != nil {
+		frame.Pos = call.Function.Pos() //f(x) 中f的位置，而不是括号的位置
 	}
+	return frame
 }
 
 func extendFunctionEnv(
@@ -400,141 +829,255 @@ func unwrapReturnValue(obj object.Object) object.Object {
 	if returnValue, ok := obj.(*object.ReturnValue); ok {
 		return returnValue.Value
 	}
+	if obj == nil { //函数体为空或者最后一条语句是let
+		return NULL
+	}
+	switch obj := obj.(type) {
+	case *object.BreakValue, *object.ContinueValue: //不能跳出函数
+		return newError(object.SYNTAX_ERROR, "%s outside loop", obj.Inspect())
+	}
 
 	return obj
 }
 
-func evalForExpression(fs *ast.ForExpression, env *object.Environment) object.Object {
-	if !(fs.Initialize == nil) { //初始化
-		Eval(fs.Initialize, env)
+func evalForExpression(fs *ast.ForExpression, outer *object.Environment) object.Object {
+	env := object.NewEnclosedEnvironment(outer) //循环头部声明的变量只在循环内可见
+
+	if fs.Initialize != nil { //初始化
+		initialized := eval(fs.Initialize, env)
+		if isError(initialized) {
+			return initialized
+		}
 	}
-	for isTruthy(Eval(fs.Condition, env)) {
-		evaluated := Eval(fs.Body, env)
 
-		// 检查循环体内语句执行后的返回值类型
-		switch evaluated := evaluated.(type) {
-		case *object.ReturnValue:
-			return evaluated.Value
-		case *object.BreakValue:
-			// 当遇到 break 语句时，直接返回 NULL，即退出循环
-			return NULL
-		case *object.ContinueValue:
-			// 当遇到 continue 语句时，重新评估条件，继续下一次循环
-			continue
+	for {
+		condition := eval(fs.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			break
 		}
 
-		// 如果在循环体内遇到了 if 语句块，则需要对其进行评估
-		if ifExpr, ok := fs.Body.Statements[len(fs.Body.Statements)-1].(*ast.IfExpression); ok {
-			if isTruthy(Eval(ifExpr.Condition, env)) {
-				// 如果条件满足，则执行 if 语句块的 Consequence
-				evaluated := Eval(ifExpr.Consequence, env)
-				switch evaluated := evaluated.(type) {
-				case *object.ReturnValue:
-					return evaluated.Value
-				case *object.BreakValue:
-					return NULL
-				case *object.ContinueValue:
-					continue
-				}
-			} else if ifExpr.Alternative != nil {
-				// 如果条件不满足且存在 Alternative，则执行 Alternative
-				evaluated := Eval(ifExpr.Alternative, env)
-				switch evaluated := evaluated.(type) {
-				case *object.ReturnValue:
-					return evaluated.Value
-				case *object.BreakValue:
-					return nil
-				case *object.ContinueValue:
-					continue
-				}
+		evaluated := eval(fs.Body, object.NewEnclosedEnvironment(env)) //每次循环都使用新的块作用域
+		if stop, result := loopControl(fs.Label, evaluated); stop {
+			return result
+		}
+
+		if fs.Cycleop != nil { //执行循环操作，continue之后同样需要执行
+			op := eval(fs.Cycleop, env)
+			if isError(op) {
+				return op
 			}
 		}
-		//执行循环操作
-		evaluated = Eval(fs.Cycleop, env)
-		// 重新评估条件
-		// 如果条件不再满足，则退出循环
-		if !isTruthy(Eval(fs.Condition, env)) {
+	}
+
+	return NULL
+}
+
+func evalWhileExpression(fs *ast.WhileExpression, env *object.Environment) object.Object {
+	for {
+		condition := eval(fs.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
 			break
 		}
+
+		evaluated := eval(fs.Body, object.NewEnclosedEnvironment(env)) //每次循环都使用新的块作用域
+		if stop, result := loopControl(fs.Label, evaluated); stop {
+			return result
+		}
 	}
+
 	return NULL
 }
 
-func evalWhileExpression(fs *ast.WhileExpression, env *object.Environment) object.Object {
-	for isTruthy(Eval(fs.Condition, env)) {
-		evaluated := Eval(fs.Body, env)
+func evalForInExpression(fi *ast.ForInExpression, env *object.Environment) object.Object {
+	iterable := eval(fi.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+	it, err := iterate(iterable)
+	if err != nil {
+		return err
+	}
 
-		// 检查循环体内语句执行后的返回值类型
-		switch evaluated := evaluated.(type) {
-		case *object.ReturnValue:
-			return evaluated.Value
-		case *object.BreakValue:
-			// 当遇到 break 语句时，直接返回 NULL，即退出循环
-			return NULL
-		case *object.ContinueValue:
-			// 当遇到 continue 语句时，重新评估条件，继续下一次循环
-			continue
+	for {
+		key, value, ok := it.Next()
+		if !ok {
+			break
 		}
 
-		// 如果在循环体内遇到了 if 语句块，则需要对其进行评估
-		if ifExpr, ok := fs.Body.Statements[len(fs.Body.Statements)-1].(*ast.IfExpression); ok {
-			if isTruthy(Eval(ifExpr.Condition, env)) {
-				// 如果条件满足，则执行 if 语句块的 Consequence
-				evaluated := Eval(ifExpr.Consequence, env)
-				switch evaluated := evaluated.(type) {
-				case *object.ReturnValue:
-					return evaluated.Value
-				case *object.BreakValue:
-					return NULL
-				case *object.ContinueValue:
-					continue
-				}
-			} else if ifExpr.Alternative != nil {
-				// 如果条件不满足且存在 Alternative，则执行 Alternative
-				evaluated := Eval(ifExpr.Alternative, env)
-				switch evaluated := evaluated.(type) {
-				case *object.ReturnValue:
-					return evaluated.Value
-				case *object.BreakValue:
-					return nil
-				case *object.ContinueValue:
-					continue
-				}
-			}
+		vars := object.NewEnclosedEnvironment(env) //每次循环都得到新的循环变量，闭包捕获的是当次的值
+		if fi.Key != nil {
+			vars.Set(fi.Key.Value, key)
 		}
+		vars.Set(fi.Value.Value, value)
 
-		// 重新评估条件
-		// 如果条件不再满足，则退出循环
-		if !isTruthy(Eval(fs.Condition, env)) {
-			break
+		evaluated := eval(fi.Body, object.NewEnclosedEnvironment(vars))
+		if stop, result := loopControl(fi.Label, evaluated); stop {
+			return result
 		}
 	}
 
 	return NULL
 }
 
+// iterate 返回遍历obj的迭代器，obj不能遍历时返回错误
+func iterate(obj object.Object) (object.Iterator, *object.Error) {
+	iterable, ok := obj.(object.Iterable)
+	if !ok {
+		return nil, newError(object.TYPE_ERROR, "cannot iterate over %s", obj.Type())
+	}
+	return iterable.Iterate(), nil
+}
+
+// loopControl 根据循环体的求值结果决定循环是否结束。
+// stop为true时，循环应当立即返回result：
+// 属于本循环的break得到NULL，return、错误以及属于外层循环的break/continue原样向外传递
+func loopControl(label *ast.Identifier, evaluated object.Object) (stop bool, result object.Object) {
+	switch evaluated := evaluated.(type) {
+	case *object.ReturnValue, *object.Error:
+		return true, evaluated
+	case *object.BreakValue:
+		if isOwnLabel(label, evaluated.Label) {
+			return true, NULL
+		}
+		return true, evaluated
+	case *object.ContinueValue:
+		if isOwnLabel(label, evaluated.Label) {
+			return false, nil
+		}
+		return true, evaluated
+	}
+	return false, nil
+}
+
+// isOwnLabel 判断break或continue的目标是否为标签为label的循环，target为空表示最内层的循环
+func isOwnLabel(label *ast.Identifier, target string) bool {
+	return target == "" || (label != nil && label.Value == target)
+}
+
 func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
+	case left.Type() == object.ERROR_VALUE_OBJ && index.Type() == object.STRING_OBJ: //捕获的错误，例如 e["message"]
+		field, ok := left.(*object.ErrorValue).Field(index.(*object.String).Value)
+		if !ok || field == nil {
+			return NULL
+		}
+		return field
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalStringIndexExpression(left, index)
 		// hash表
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 	default:
-		return newError("index operator not supported: %s", left.Type())
+		return newError(object.TYPE_ERROR, "index operator not supported: %s", left.Type())
 	}
 }
 
 func evalArrayIndexExpression(array, index object.Object) object.Object {
 	arrayObject := array.(*object.Array)
-	idx := index.(*object.Integer).Value
-	max := int64(len(arrayObject.Elements) - 1)
-	if idx < 0 || idx > max {
-		return NULL
+	idx, ok := arrayIndex(index.(*object.Integer).Value, len(arrayObject.Elements))
+	if !ok {
+		return newError(object.INDEX_ERROR, "index out of range: %d (length %d)",
+			index.(*object.Integer).Value, len(arrayObject.Elements))
 	}
 	return arrayObject.Elements[idx]
 }
 
+// evalStringIndexExpression 字符串按字符计算下标，与切片和 for i, ch in s 一致，结果是只有一个字符的字符串
+func evalStringIndexExpression(str, index object.Object) object.Object {
+	runes := []rune(str.(*object.String).Value)
+	idx, ok := arrayIndex(index.(*object.Integer).Value, len(runes))
+	if !ok {
+		return newError(object.INDEX_ERROR, "index out of range: %d (length %d)",
+			index.(*object.Integer).Value, len(runes))
+	}
+	return &object.String{Value: string(runes[idx])}
+}
+
+// arrayIndex 把负数下标转换为从头开始的下标，-1是最后一个元素。下标越界时ok为false
+func arrayIndex(idx int64, length int) (int64, bool) {
+	if idx < 0 {
+		idx += int64(length)
+	}
+	return idx, idx >= 0 && idx < int64(length)
+}
+
+func evalSliceExpression(node *ast.SliceExpression, env *object.Environment) object.Object {
+	left := eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	bounds := []object.Object{NULL, NULL} //省略的下标为null
+	for i, exp := range []ast.Expression{node.Low, node.High} {
+		if exp == nil {
+			continue
+		}
+		bounds[i] = eval(exp, env)
+		if isError(bounds[i]) {
+			return bounds[i]
+		}
+	}
+	return slice(left, bounds[0], bounds[1])
+}
+
+// slice 计算 left[low:high]，结果是新的数组或字符串。字符串按字符切片，与 for i, ch in s 的序号一致
+func slice(left, low, high object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		lo, hi, err := sliceBounds(low, high, len(left.Elements))
+		if err != nil {
+			return err
+		}
+		elements := make([]object.Object, hi-lo)
+		copy(elements, left.Elements[lo:hi])
+		return &object.Array{Elements: elements}
+	case *object.String:
+		runes := []rune(left.Value)
+		lo, hi, err := sliceBounds(low, high, len(runes))
+		if err != nil {
+			return err
+		}
+		return &object.String{Value: string(runes[lo:hi])}
+	default:
+		return newError(object.TYPE_ERROR, "slice operator not supported: %s", left.Type())
+	}
+}
+
+// sliceBounds 计算切片的范围。low为null时从头开始，high为null时直到末尾，
+// 负数下标从末尾开始计算，超出范围的下标截断到两端，所以切片不会越界
+func sliceBounds(low, high object.Object, length int) (lo, hi int, err *object.Error) {
+	if lo, err = sliceIndex(low, 0, length); err != nil {
+		return 0, 0, err
+	}
+	if hi, err = sliceIndex(high, length, length); err != nil {
+		return 0, 0, err
+	}
+	return lo, max(lo, hi), nil
+}
+
+func sliceIndex(index object.Object, omitted, length int) (int, *object.Error) {
+	if index == NULL {
+		return omitted, nil
+	}
+	i, ok := index.(*object.Integer)
+	if !ok {
+		return 0, newError(object.TYPE_ERROR, "slice index must be INTEGER, got %s", index.Type())
+	}
+	idx := i.Value
+	if idx < 0 {
+		idx += int64(length)
+	}
+	return int(min(max(idx, 0), int64(length))), nil
+}
+
 func evalHashLiteral(
 	node *ast.HashLiteral,
 	env *object.Environment,
@@ -542,17 +1085,17 @@ func evalHashLiteral(
 	pairs := make(map[object.HashKey]object.HashPair)
 
 	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env)
+		key := eval(keyNode, env)
 		if isError(key) {
 			return key
 		}
 
 		hashKey, ok := key.(object.Hashable)
 		if !ok {
-			return newError("unusable as hash key: %s", key.Type())
+			return newError(object.TYPE_ERROR, "unusable as hash key: %s", key.Type())
 		}
 
-		value := Eval(valueNode, env)
+		value := eval(valueNode, env)
 		if isError(value) {
 			return value
 		}
@@ -569,7 +1112,7 @@ func evalHashIndexExpression(hash, index object.Object) object.Object {
 
 	key, ok := index.(object.Hashable)
 	if !ok {
-		return newError("unusable as hash key: %s", index.Type())
+		return newError(object.TYPE_ERROR, "unusable as hash key: %s", index.Type())
 	}
 	pair, ok := hashObject.Pairs[key.HashKey()]
 	if !ok {
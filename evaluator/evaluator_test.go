@@ -1 +1,904 @@
 package evaluator
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"my.com/myfile/lexer"
+	"my.com/myfile/object"
+	"my.com/myfile/parser"
+)
+
+func testEval(input string) object.Object {
+	l := lexer.NewFile("test.wz", input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	env := object.NewEnvironment()
+
+	return Eval(program, env)
+}
+
+func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
+	result, ok := obj.(*object.Integer)
+	if !ok {
+		t.Errorf("object is not Integer. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%d, want=%d",
+			result.Value, expected)
+		return false
+	}
+
+	return true
+}
+
+func TestErrorPosition(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{
+			"let a = 1;\nlet b = a + x;",
+			"ERROR: test.wz:2:13: identifier not found: x",
+		},
+		{
+			"5;\n  true + false;",
+			"ERROR: test.wz:2:8: unknown operator: BOOLEAN + BOOLEAN",
+		},
+		{
+			"if (10 > 1) {\n  -true\n}",
+			"ERROR: test.wz:2:3: unknown operator: -BOOLEAN",
+		},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Inspect() != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Inspect())
+		}
+	}
+}
+
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1.5", "1.5"},
+		{"-2.25", "-2.25"},
+		{"1.5e-3", "0.0015"},
+		{"2e3", "2000.0"},
+		{"1.0", "1.0"},
+		{"0.1 + 0.2", "0.30000000000000004"},
+		{"10 / 4.0", "2.5"},
+		{"3 * 1.5", "4.5"},
+		{"1e21 * 10", "1e+22"},
+		{"let sum = 7; sum / 2.0", "3.5"},
+		{"1 < 1.5", "true"},
+		{"2.0 == 2", "true"},
+		{"2.5 >= 3", "false"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil {
+			t.Errorf("%q evaluated to nil", tt.input)
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestFloatHashKeys(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let h = {1.0: "a"}; h[1] = "b"; let n = 0; for k in h { n += 1 }; [n, h[1], h[1.0]]`, "[1, b, b]"},
+		{`let h = {2: "int"}; [h[2.0], h[-0.0], h[2.5]]`, "[int, null, null]"},
+		{`let h = {0: "zero", 2.5: "x"}; [h[-0.0], h[2.5], h[5 / 2.0]]`, "[zero, x, x]"},
+		{`{1e300: 1}[1e300]`, "1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil {
+			t.Errorf("%q evaluated to nil", tt.input)
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestAssignExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let a = 5; a = 10; a;", 10},
+		{"let a = 5; a = a + 1;", 6},
+		{"let a = 1; let b = 2; a = b = 7; a + b;", 14},
+		{"let a = 10; a += 5; a;", 15},
+		{"let a = 10; a -= 5; a;", 5},
+		{"let a = 10; a *= 5; a;", 50},
+		{"let a = 10; a /= 5; a;", 2},
+		{"let count = 0; let inc = fn() { count += 1; }; inc(); inc(); count;", 2},
+		{"let i = 0; let f = fn() { let i = 100; i = 1; }; f(); i;", 0},
+		{"let i = 0; while (i < 10) { i = i + 1; } i;", 10},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestAssignExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"x = 1", "assignment to undeclared variable: x"},
+		{"x += 1", "identifier not found: x"},
+		{"let s = \"a\"; s -= 1", "type mismatch: STRING - INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
+func TestIndexAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = [1, 2, 3]; a[0] = 10; a", "[10, 2, 3]"},
+		{"let a = [1, 2, 3]; a[1] += 5; a", "[1, 7, 3]"},
+		{"let a = [1, 2, 3]; let b = a; b[2] = 0; a", "[1, 2, 0]"},
+		{`let h = {"k": 1}; h["k"] = 2; h["k"]`, "2"},
+		{`let h = {}; h["new"] = "v"; h["new"]`, "v"},
+		{`let h = {}; h[true] = 1; h[true] *= 7; h[true]`, "7"},
+		{`let m = {"a": [1, 2]}; m["a"][0] = 5; m["a"]`, "[5, 2]"},
+		{`let m = [[1], [2]]; m[1][0] = "x"; m`, "[[1], [x]]"},
+		{"let a = [0]; a[0] = 3", "3"},
+		{"let a = [1, 2, 3]; a[-1] = 9; a[-3] += 1; a", "[2, 2, 9]"},
+		{"let a = [1]; a[0] = a; a", "[[...]]"},
+		{"let a = [1]; let b = [a, a]; a[0] = b; b", "[[[...]], [[...]]]"},
+		{`let h = {}; h["self"] = h; h`, "{self: {...}}"},
+		{`let h = {}; h["l"] = [h]; h`, "{l: [{...}]}"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil {
+			t.Errorf("%q evaluated to nil", tt.input)
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestIndexAssignmentErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"let a = [1]; a[1] = 2", "index out of range: 1 (length 1)"},
+		{"let a = [1]; a[-2] = 2", "index out of range: -2 (length 1)"},
+		{`let a = [1]; a["0"] = 2`, "array index must be INTEGER, got STRING"},
+		{`let h = {}; h[[1]] = 2`, "unusable as hash key: ARRAY"},
+		{`let s = "str"; s[0] = "S"`, "index assignment not supported: STRING"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
+func TestLogicalExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"true && true", "true"},
+		{"true && false", "false"},
+		{"false || true", "true"},
+		{"1 && 2", "2"},
+		{"0 || 5", "0"},
+		{"false || \"default\"", "default"},
+		{"if (false) { 1 } || 3", "3"},
+		{"1 < 2 && 2 < 3", "true"},
+		{"1 > 2 || 2 > 3", "false"},
+		{"true or false and false", "true"},
+		{"(true or false) and false", "false"},
+		{"let calls = 0; let f = fn() { calls += 1; true }; false && f(); true || f(); calls", "0"},
+		{"let calls = 0; let f = fn() { calls += 1; true }; true && f(); false || f(); calls", "2"},
+		{"false && undefined", "false"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil {
+			t.Errorf("%q evaluated to nil", tt.input)
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestIntegerOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"17 % 5", "2"},
+		{"-17 % 5", "-2"},
+		{"10 - 7 % 4", "7"},
+		{"2 ** 10", "1024"},
+		{"2 ** 3 ** 2", "512"},
+		{"-2 ** 2", "-4"},
+		{"(-2) ** 2", "4"},
+		{"2 * 3 ** 2", "18"},
+		{"2 ** -1", "0.5"},
+		{"2.0 ** 0.5 > 1.41", "true"},
+		{"7.5 % 2", "1.5"},
+		{"6 & 3", "2"},
+		{"6 | 3", "7"},
+		{"6 ^ 3", "5"},
+		{"~5", "-6"},
+		{"1 << 4", "16"},
+		{"-16 >> 2", "-4"},
+		{"1 + 2 << 1", "5"},
+		{"5 & 1 == 1", "true"},
+		{"let h = 0; h = (h * 31 + 7) % 1000; h % 16", "7"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil {
+			t.Errorf("%q evaluated to nil", tt.input)
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestIntegerOperatorErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"1 << -1", "negative shift count: -1"},
+		{"8 >> -2", "negative shift count: -2"},
+		{"5 % 0", "division by zero: 5 % 0"},
+		{"1.5 & 1", "unknown operator: FLOAT & INTEGER"},
+		{"~1.5", "unknown operator: ~FLOAT"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"1 / 0", "division by zero: 1 / 0"},
+		{"let a = 10; a /= 0", "division by zero: 10 / 0"},
+		{"let m = -9223372036854775807 - 1; m / -1", "integer overflow: -9223372036854775808 / -1"},
+		{"let f = fn(x, y) { x + y }; f(1)", "wrong number of arguments to f: expected 2, got 1"},
+		{"fn f() { 1 }; f(1, 2)", "wrong number of arguments to f: expected 0, got 2"},
+		{"fn(x) { x }()", "wrong number of arguments to <anonymous>: expected 1, got 0"},
+		{"let f = fn(x) { x / 0 }; f(1) + 1", "division by zero: 1 / 0"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%q: no error object returned. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
+func TestEvalRecoversFromPanic(t *testing.T) {
+	builtins["boom"] = &object.Builtin{
+		Fn: func(rt *object.Runtime, args ...object.Object) object.Object {
+			panic("something went wrong")
+		},
+	}
+	defer delete(builtins, "boom")
+
+	evaluated := testEval("let a = 1;\nboom()")
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "internal error: something went wrong" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestEmptyResultsAreNull(t *testing.T) {
+	tests := []string{
+		"let f = fn() {}; f()",
+		"let f = fn() { let x = 1; }; f() == f()",
+		"let a = if (true) {}; a",
+		"puts()",
+	}
+
+	for _, input := range tests {
+		evaluated := testEval(input)
+		if evaluated == nil || isError(evaluated) {
+			t.Errorf("%q: expected a value, got=%v", input, evaluated)
+		}
+	}
+}
+
+func TestLoopControl(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let i = 0; while (true) { i += 1; if (i == 5) { break } } i", "5"},
+		{
+			"let i = 0; let s = 0; while (i < 10) { i += 1; if (i % 2 == 0) { continue; } s += i; } s",
+			"25",
+		},
+		{
+			"let s = 0; for let i = 0 : i < 10 : i += 1 { if (i % 2 == 1) { continue } s += i } s",
+			"20",
+		},
+		{
+			"let n = 0; for let i = 0 : i < 10 : i += 1 { n += 1; if (i == 3) { break; } } n",
+			"4",
+		},
+		{
+			"let calls = 0; let i = 0; while (i < 3) { i += 1; if (true) { calls += 1; break; } } calls",
+			"1",
+		},
+		{
+			`let pairs = 0;
+outer: for let i = 0 : i < 5 : i += 1 {
+	for let j = 0 : j < 5 : j += 1 {
+		if (j > i) { continue outer; }
+		if (i == 3) { break outer; }
+		pairs += 1;
+	}
+}
+pairs`,
+			"6",
+		},
+		{
+			`let i = 0;
+outer: while (true) {
+	while (true) {
+		i += 1;
+		if (i > 2) { break outer }
+	}
+}
+i`,
+			"3",
+		},
+		{"let f = fn() { while (true) { return 7; } }; f()", "7"},
+		{"let i = 0; while (i < 3) { i += 1; } ", "null"},
+		{"while (false) {}", "null"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil {
+			t.Errorf("%q evaluated to nil", tt.input)
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestForIn(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let s = 0; for x in [1, 2, 3] { s += x } s", "6"},
+		{"let r = []; for i, x in [10, 20] { r = push(r, i * 100 + x) } r", "[10, 120]"},
+		{`let r = []; for k, v in {"b": 2, "a": 1, 3: "c"} { r = push(r, [k, v]) } r`, "[[3, c], [a, 1], [b, 2]]"},
+		{`let r = []; for v in {"b": 2, "a": 1} { r = push(r, v) } r`, "[1, 2]"},
+		{`let r = []; for i, ch in "héllo" { r = push(r, [i, ch]) } r`, "[[0, h], [1, é], [2, l], [3, l], [4, o]]"},
+		{"let s = 0; for i in 0..10 { s += i } s", "45"},
+		{"let s = 0; for i, v in 5..8 { s += i * v } s", "20"},
+		{"let n = 0; for i in 3..3 { n += 1 } n", "0"},
+		{"0..5", "0..5"},
+		{"for x in [] { }", "null"},
+		{"let s = 0; for i in 0..10 { if (i % 2 == 0) { continue } if (i > 6) { break } s += i } s", "9"},
+		{
+			`let pairs = 0;
+outer: for i in 0..5 {
+	for j in 0..5 {
+		if (j > i) { continue outer; }
+		if (i == 3) { break outer; }
+		pairs += 1;
+	}
+}
+pairs`,
+			"6",
+		},
+		{"let fns = []; for i in 0..3 { fns = push(fns, fn() { i }) } fns[0]() + fns[2]()", "2"},
+		{"let f = fn(a) { for x in a { if (x > 1) { return x } } 0 }; f([1, 5, 7])", "5"},
+		{"let x = 42; for x in [1, 2] { let x = 3 } x", "42"},
+		{"for x in [1] { } x", "ERROR: test.wz:1:18: identifier not found: x"},
+		{"for x in 5 { }", "ERROR: test.wz:1:1: cannot iterate over INTEGER"},
+		{"1.5..2", "ERROR: test.wz:1:4: unknown operator: FLOAT .. INTEGER"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil {
+			t.Errorf("%q evaluated to nil", tt.input)
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestRangesAndSlices(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let r = []; for i in 0..=3 { r = push(r, i) } r", "[0, 1, 2, 3]"},
+		{"let r = []; for i in step(0..10, 3) { r = push(r, i) } r", "[0, 3, 6, 9]"},
+		{"let r = []; for i in step(10..=0, -5) { r = push(r, i) } r", "[10, 5, 0]"},
+		{"let r = []; for i in 5..0 { r = push(r, i) } r", "[]"},
+		{"let r = []; for i in step(9223372036854775806..=9223372036854775807, 2) { r = push(r, i) } r", "[9223372036854775806]"},
+		{"[0..=2, step(1..5, 2), 1 + 1..2 * 3]", "[0..=2, step(1..5, 2), 2..6]"},
+		{"step(0..1, 0)", "ERROR: step must not be zero"},
+		{"step([1], 1)", "ERROR: first argument to `step` must be RANGE, got ARRAY"},
+		{"[1, 2, 3][-1]", "3"},
+		{"[1, 2, 3][-3]", "1"},
+		{"[1, 2, 3][-4]", "ERROR: index out of range: -4 (length 3)"},
+		{"let a = [1, 2, 3, 4, 5]; [a[1:3], a[:2], a[3:], a[-2:], a[:-1], a[:]]", "[[2, 3], [1, 2], [4, 5], [4, 5], [1, 2, 3, 4], [1, 2, 3, 4, 5]]"},
+		{"let a = [1, 2, 3]; [a[2:1], a[5:], a[-10:1], a[1:100]]", "[[], [], [1], [2, 3]]"},
+		{"let a = [1, 2, 3]; let b = a[:]; b[0] = 9; a", "[1, 2, 3]"},
+		{`let s = "hello"; [s[:5], s[1:3], s[-3:], s[10:]]`, "[hello, el, llo, ]"},
+		{`"héllo"[1:3]`, "él"},
+		{`let s = "héllo"; [s[0], s[1], s[-1], s[-5]]`, "[h, é, o, h]"},
+		{`let s = "héllo"; [len(s), s[len(s) - 1], s[1:len(s)]]`, "[5, o, éllo]"},
+		{`"abc"[3]`, "ERROR: index out of range: 3 (length 3)"},
+		{`"abc"[-4]`, "ERROR: index out of range: -4 (length 3)"},
+		{`"abc"["a"]`, "ERROR: index operator not supported: STRING"},
+		{`let s = "abc"; s[0] = "x"`, "ERROR: index assignment not supported: STRING"},
+		{`[1, 2]["a":]`, "ERROR: slice index must be INTEGER, got STRING"},
+		{`{"a": 1}[0:1]`, "ERROR: slice operator not supported: HASH"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil {
+			t.Errorf("%q evaluated to nil", tt.input)
+			continue
+		}
+		got := evaluated.Inspect()
+		if errObj, ok := evaluated.(*object.Error); ok {
+			got = "ERROR: " + errObj.Message
+		}
+		if got != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestBlockScope(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let j = 100; let i = 0; while (i < 3) { let j = i; i += 1; } j", "100"},
+		{"let x = 1; if (true) { let x = 2; } x", "1"},
+		{"let x = 1; if (false) { 0 } else { let x = 3; x }", "3"},
+		{"let x = 1; if (true) { x = 2; } x", "2"},
+		{"let i = 42; for let i = 0 : i < 3 : i += 1 { } i", "42"},
+		{
+			"let total = 0; for let i = 0 : i < 3 : i += 1 { for let i = 0 : i < 2 : i += 1 { total += 1 } } total",
+			"6",
+		},
+		{
+			"let fns = []; let i = 0; while (i < 3) { let v = i; fns = push(fns, fn() { v }); i += 1; } fns[0]() + fns[2]()",
+			"2",
+		},
+		{"let i = 0; while (i < 2) { let inner = 1; i += 1; } inner", "ERROR: test.wz:1:53: identifier not found: inner"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil {
+			t.Errorf("%q evaluated to nil", tt.input)
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestBuiltinOutputUsesRuntime(t *testing.T) {
+	var stdout bytes.Buffer
+	rt := &object.Runtime{Stdin: strings.NewReader(""), Stdout: &stdout, Stderr: &stdout}
+	env := object.NewEnvironmentWithRuntime(rt)
+
+	input := `
+let show = fn(x) { puts(x, " ") };
+show(1);
+if (true) { show("two") };
+puts([3], "\n")
+`
+	program := parser.New(lexer.New(input)).ParseProgram()
+	if evaluated := Eval(program, env); evaluated != NULL {
+		t.Fatalf("puts should return NULL. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	if stdout.String() != "1 two [3]\n" {
+		t.Errorf("wrong output. got=%q", stdout.String())
+	}
+}
+
+func TestExecutionLimits(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+
+	tests := []struct {
+		input    string
+		runtime  *object.Runtime
+		expected string
+	}{
+		{"while (true) {}", &object.Runtime{Limits: object.Limits{MaxSteps: 1000}}, "step limit exceeded"},
+		{"let i = 0; while (i < 10) { i += 1 }; i", &object.Runtime{Limits: object.Limits{MaxSteps: 1000}}, ""},
+		{"while (true) {}", &object.Runtime{Limits: object.Limits{Timeout: 10 * time.Millisecond}}, "execution timed out"},
+		{"while (true) {}", &object.Runtime{Context: cancelled}, "execution cancelled"},
+		{"while (true) {}", &object.Runtime{Context: expired}, "execution timed out"},
+		{"let f = fn(n) { f(n + 1) }; f(0)", &object.Runtime{}, "maximum recursion depth exceeded"},
+		{"let f = fn(n) { if (n > 0) { f(n - 1) } }; f(50)", &object.Runtime{Limits: object.Limits{MaxDepth: 50}}, "maximum recursion depth exceeded"},
+		{"let f = fn(n) { if (n > 0) { f(n - 1) } }; f(49)", &object.Runtime{Limits: object.Limits{MaxDepth: 50}}, ""},
+		{"let f = fn() { 1 }; for let i = 0 : true : i += 1 { f() }", &object.Runtime{Limits: object.Limits{MaxSteps: 5000}}, "step limit exceeded"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := Eval(program, object.NewEnvironmentWithRuntime(tt.runtime))
+
+		errObj, isErr := evaluated.(*object.Error)
+		if tt.expected == "" {
+			if isErr {
+				t.Errorf("%q: unexpected error %s", tt.input, errObj.Message)
+			}
+			continue
+		}
+		if !isErr {
+			t.Errorf("%q: expected error %q, got %T (%+v)", tt.input, tt.expected, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("%q: wrong error message. expected=%q, got=%q", tt.input, tt.expected, errObj.Message)
+		}
+	}
+}
+
+func TestLimitsResetBetweenEvals(t *testing.T) {
+	rt := &object.Runtime{Limits: object.Limits{MaxSteps: 100, MaxDepth: 3}}
+	env := object.NewEnvironmentWithRuntime(rt)
+
+	for i := 0; i < 5; i++ {
+		program := parser.New(lexer.New("let f = fn(n) { if (n > 0) { f(n - 1) } }; f(2); 1 + 2 * 3")).ParseProgram()
+		if evaluated := Eval(program, env); isError(evaluated) {
+			t.Fatalf("run %d: unexpected error %s", i, evaluated.Inspect())
+		}
+	}
+	if rt.Steps() == 0 || rt.Steps() > 100 {
+		t.Errorf("wrong step count %d", rt.Steps())
+	}
+}
+
+func TestErrorStack(t *testing.T) {
+	input := `let inner = fn(x) {
+  x / 0
+};
+let outer = fn(x) { inner(x) + 1 };
+outer(1);`
+
+	errObj, ok := testEval(input).(*object.Error)
+	if !ok {
+		t.Fatalf("expected error")
+	}
+	if got := errObj.Pos.String(); got != "test.wz:2:5" {
+		t.Errorf("wrong error position. got=%s", got)
+	}
+
+	expected := []string{"inner test.wz:4:21", "outer test.wz:5:1"}
+	if len(errObj.Stack) != len(expected) {
+		t.Fatalf("wrong number of frames. expected=%d, got=%d (%v)", len(expected), len(errObj.Stack), errObj.Stack)
+	}
+	for i, frame := range errObj.Stack {
+		if got := frame.Function + " " + frame.Pos.String(); got != expected[i] {
+			t.Errorf("frame %d: expected %q, got %q", i, expected[i], got)
+		}
+	}
+
+	traceback := "traceback (most recent call first):\n" +
+		"  in inner, called at test.wz:4:21\n" +
+		"  in outer, called at test.wz:5:1\n"
+	if errObj.Traceback() != traceback {
+		t.Errorf("wrong traceback.\nexpected=%q\ngot=%q", traceback, errObj.Traceback())
+	}
+}
+
+func TestErrorStackFrames(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"1 / 0", nil},
+		{"len(1)", nil},
+		{"let f = fn(x) { x }; f(1, 2)", nil},
+		{"fn() { 1 / 0 }()", []string{"<anonymous>"}},
+		{"let f = fn() { len(1) }; f()", []string{"f"}},
+		{"let f = fn() { undefined }; let g = fn() { f() }; g()", []string{"f", "g"}},
+		{"let f = fn(n) { if (n == 0) { 1 / 0 } else { f(n - 1) } }; f(2)", []string{"f", "f", "f"}},
+	}
+
+	for _, tt := range tests {
+		errObj, ok := testEval(tt.input).(*object.Error)
+		if !ok {
+			t.Errorf("%q: expected error", tt.input)
+			continue
+		}
+		var names []string
+		for _, frame := range errObj.Stack {
+			names = append(names, frame.Function)
+		}
+		if strings.Join(names, ",") != strings.Join(tt.expected, ",") {
+			t.Errorf("%q: expected frames %v, got %v", tt.input, tt.expected, names)
+		}
+		if len(tt.expected) == 0 && errObj.Traceback() != "" {
+			t.Errorf("%q: expected empty traceback, got %q", tt.input, errObj.Traceback())
+		}
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { 1 } catch (e) { 2 }", "1"},
+		{"try { 1 / 0 } catch (e) { 2 }", "2"},
+		{"try { 1 / 0 } catch (e) { e[\"kind\"] }", "ZeroDivisionError"},
+		{"try { 1 / 0 } catch (e) { e[\"message\"] }", "division by zero: 1 / 0"},
+		{"try { 1 / 0 } catch (e) { e }", "ZeroDivisionError: division by zero: 1 / 0"},
+		{"try { 1 / 0 } catch (e) { e[\"value\"] }", "null"},
+		{"try { 1 / 0 } catch (e) { e[\"unknown\"] }", "null"},
+		{"try { x } catch (e) { e[\"kind\"] }", "NameError"},
+		{"try { 1 + true } catch (e) { e[\"kind\"] }", "TypeError"},
+		{"try { len(1, 2) } catch (e) { e[\"kind\"] }", "ArgumentError"},
+		{"try { [1][5] = 2 } catch (e) { e[\"kind\"] }", "IndexError"},
+		{"try { [1][5] } catch (e) { e[\"kind\"] + \": \" + e[\"message\"] }", "IndexError: index out of range: 5 (length 1)"},
+		{"try { [1][-2] } catch (e) { e[\"message\"] }", "index out of range: -2 (length 1)"},
+		{"try { throw \"boom\" } catch (e) { e[\"kind\"] + \": \" + e[\"message\"] }", "Error: boom"},
+		{"try { throw {\"code\": 7} } catch (e) { e[\"value\"][\"code\"] }", "7"},
+		{"try { throw 42 } catch (e) { e[\"message\"] }", "42"},
+		{"let f = fn() { throw \"x\" }; try { f() } catch (e) { length(e[\"stack\"]) }", "1"},
+		{"let f = fn() { throw \"x\" }; try { f() } catch (e) { first(e[\"stack\"]) }", "in f, called at test.wz:1:35"},
+		// catch中的变量只在catch块中可见
+		{"let e = 1; try { throw 2 } catch (e) { }; e", "1"},
+		// 重新抛出原来的错误
+		{"try { try { 1 / 0 } catch (e) { throw e } } catch (e2) { e2[\"kind\"] }", "ZeroDivisionError"},
+		// 重新抛出的是副本，不会改变捕获的错误的调用栈
+		{`let f = fn() { throw "x" }
+let saved = try { f() } catch (e) { e }
+let g = fn() { throw saved }
+let again = try { g() } catch (e) { e }
+try { g() } catch (e) { };
+[length(saved["stack"]), length(again["stack"])]`, "[1, 2]"},
+		// 批量处理时跳过出错的记录
+		{`let total = 0;
+let records = [1, 0, 2, "x", 4];
+for let i = 0 : i < length(records) : i += 1 {
+	try { total += 12 / records[i] } catch (e) { continue }
+}
+total`, "21"},
+		{"let f = fn() { try { return 1 } catch (e) { 2 } }; f()", "1"},
+		{"let f = fn() { try { throw 1 } catch (e) { return 2 } }; f()", "2"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil {
+			t.Errorf("%q evaluated to nil", tt.input)
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestTryFinally(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let log = []; try { log = push(log, 1) } finally { log = push(log, 2) }; log", "[1, 2]"},
+		{"let log = []; try { 1 / 0 } catch (e) { log = push(log, 1) } finally { log = push(log, 2) }; log", "[1, 2]"},
+		{"try { 1 } finally { 2 }", "1"},
+		{"try { 1 / 0 } catch (e) { 2 } finally { 3 }", "2"},
+		{"let log = []; let f = fn() { try { return 1 } finally { log = push(log, \"f\") } }; [f(), log]", "[1, [f]]"},
+		{"let f = fn() { try { return 1 } finally { return 2 } }; f()", "2"},
+		{"let n = 0; while (true) { try { break } finally { n += 1 } }; n", "1"},
+		{"try { 1 / 0 } catch (e) { 2 } finally { throw \"finally\" }", "ERROR: test.wz:1:41: finally"},
+		{"let n = 0; let r = try { try { 1 / 0 } finally { n += 1 } } catch (e) { e[\"kind\"] }; [r, n]", "[ZeroDivisionError, 1]"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil {
+			t.Errorf("%q evaluated to nil", tt.input)
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestUncaughtThrow(t *testing.T) {
+	errObj, ok := testEval("let x = 1;\nthrow \"bad \" + \"input\";").(*object.Error)
+	if !ok {
+		t.Fatalf("expected error")
+	}
+	if errObj.Kind != object.ERROR_KIND || errObj.Message != "bad input" || errObj.Pos.String() != "test.wz:2:1" {
+		t.Errorf("wrong error. got=%+v", errObj)
+	}
+	if value, ok := errObj.Value.(*object.String); !ok || value.Value != "bad input" {
+		t.Errorf("wrong thrown value. got=%v", errObj.Value)
+	}
+}
+
+func TestLimitErrorsAreNotCatchable(t *testing.T) {
+	rt := &object.Runtime{Limits: object.Limits{MaxSteps: 1000}}
+	input := "let caught = 0; try { while (true) {} } catch (e) { caught = 1 } finally { caught = 2 }"
+	program := parser.New(lexer.New(input)).ParseProgram()
+	env := object.NewEnvironmentWithRuntime(rt)
+
+	errObj, ok := Eval(program, env).(*object.Error)
+	if !ok || errObj.Message != "step limit exceeded" || errObj.Kind != object.LIMIT_ERROR {
+		t.Fatalf("expected step limit error, got %+v", errObj)
+	}
+	if caught, _ := env.Get("caught"); caught.Inspect() != "0" {
+		t.Errorf("catch or finally ran after the step limit. caught=%s", caught.Inspect())
+	}
+}
+
+func TestFunctionDeclaration(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn add(a, b) { a + b } add(1, 2)", "3"},
+		{"fn add(a, b) { a + b }; add", "fn add(a, b)"},
+		{"let double = fn(x) { x * 2 }; double", "fn double(x)"},
+		{"fn(x) { x }", "fn(x)"},
+		{"let f = fn() { 1 }; let g = f; g", "fn f()"},
+		// 声明之前调用
+		{"let r = twice(4); fn twice(x) { x * 2 } r", "8"},
+		// 互相递归的函数，声明顺序无关
+		{`fn isEven(n) { if (n == 0) { true } else { isOdd(n - 1) } }
+fn isOdd(n) { if (n == 0) { false } else { isEven(n - 1) } }
+[isEven(10), isOdd(7), isEven(3)]`, "[true, true, false]"},
+		{`let r = isOdd(5);
+fn isOdd(n) { if (n == 0) { false } else { isEven(n - 1) } }
+fn isEven(n) { if (n == 0) { true } else { isOdd(n - 1) } }
+r`, "true"},
+		// 函数体内的声明只在函数内可见，也会被提升
+		{"fn outer() { return inner() + 1; fn inner() { 41 } } outer()", "42"},
+		{"fn outer() { fn inner() { 1 } 0 } outer(); try { inner() } catch (e) { e[\"kind\"] }", "NameError"},
+		{"fn fact(n) { if (n <= 1) { return 1 } n * fact(n - 1) } fact(10)", "3628800"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil {
+			t.Errorf("%q evaluated to nil", tt.input)
+			continue
+		}
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestFunctionNamesInStack(t *testing.T) {
+	input := `fn apply(f, x) { f(x) }
+fn check(x) { if (x < 0) { throw "negative" } x }
+apply(check, -1)`
+
+	errObj, ok := testEval(input).(*object.Error)
+	if !ok {
+		t.Fatalf("expected error")
+	}
+	expected := "traceback (most recent call first):\n" +
+		"  in check, called at test.wz:1:18\n" +
+		"  in apply, called at test.wz:3:1\n"
+	if errObj.Traceback() != expected {
+		t.Errorf("wrong traceback.\nexpected=%q\ngot=%q", expected, errObj.Traceback())
+	}
+}
@@ -0,0 +1,56 @@
+package evaluator
+
+import "my.com/myfile/object"
+
+// 下面的函数供vm使用，两种执行方式共用同一套运算规则和错误信息
+
+// InfixOperation 计算 left operator right，operator不包括 && 和 ||
+func InfixOperation(operator string, left, right object.Object) object.Object {
+	return evalInfixExpression(operator, left, right)
+}
+
+// PrefixOperation 计算 operator right，operator是 !、- 或 ~
+func PrefixOperation(operator string, right object.Object) object.Object {
+	return evalPrefixExpression(operator, right)
+}
+
+// IndexOperation 计算 left[index]
+func IndexOperation(left, index object.Object) object.Object {
+	return evalIndexExpression(left, index)
+}
+
+// SliceOperation 计算 left[low:high]，省略的下标为NULL
+func SliceOperation(left, low, high object.Object) object.Object {
+	return slice(left, low, high)
+}
+
+// CheckIndexAssignment 检查能否给left[index]赋值，可以时返回nil
+func CheckIndexAssignment(left, index object.Object) *object.Error {
+	return checkIndexTarget(left, index)
+}
+
+// SetIndex 执行 left[index] = val，调用之前必须用CheckIndexAssignment检查
+func SetIndex(left, index, val object.Object) {
+	setIndex(left, index, val)
+}
+
+// Iterate 返回 for-in 遍历obj使用的迭代器，obj不能遍历时返回错误
+func Iterate(obj object.Object) (object.Iterator, *object.Error) {
+	return iterate(obj)
+}
+
+// IsTruthy 判断值在条件中是否为真，只有false和null为假
+func IsTruthy(obj object.Object) bool {
+	return isTruthy(obj)
+}
+
+// ThrowValue 把throw的值转换成错误
+func ThrowValue(val object.Object) *object.Error {
+	return throwValue(val)
+}
+
+// LookupBuiltin 返回名为name的内置函数
+func LookupBuiltin(name string) (*object.Builtin, bool) {
+	builtin, ok := builtins[name]
+	return builtin, ok
+}
@@ -19,6 +19,10 @@ require (
     my.com/myfile/ast v0.0.0
     my.com/myfile/evaluator v0.0.0
     my.com/myfile/object v0.0.0
+    my.com/myfile/code v0.0.0
+    my.com/myfile/compiler v0.0.0
+    my.com/myfile/vm v0.0.0
+    my.com/myfile/bench v0.0.0
     my.com/myfile/repl v0.0.0
 )
 
@@ -29,6 +33,10 @@ replace (
     my.com/myfile/ast => ./ast
     my.com/myfile/evaluator => ./evaluator
     my.com/myfile/object => ./object
+    my.com/myfile/code => ./code
+    my.com/myfile/compiler => ./compiler
+    my.com/myfile/vm => ./vm
+    my.com/myfile/bench => ./bench
     my.com/myfile/repl => ./repl
 )
 
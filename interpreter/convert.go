@@ -0,0 +1,399 @@
+package interpreter
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"my.com/myfile/evaluator"
+	"my.com/myfile/object"
+)
+
+// Go的值与Wizard的值的对应关系：
+//
+//	bool                      BOOLEAN
+//	int, int8 ... uint64      INTEGER
+//	float32, float64          FLOAT
+//	string                    STRING
+//	slice, array              ARRAY
+//	map                       HASH，键必须是字符串、整数或布尔值
+//	struct                    HASH，键是字段名，可以用 `wizard:"name"` 标签修改，`wizard:"-"` 表示忽略
+//	nil, nil指针              NULL
+//
+// object.Object直接使用，指针转换为它指向的值
+
+var objectType = reflect.TypeOf((*object.Object)(nil)).Elem()
+
+// ToObject 把Go的值转换成Wizard的值
+func ToObject(v interface{}) (object.Object, error) {
+	if v == nil {
+		return evaluator.NULL, nil
+	}
+	return toObject(reflect.ValueOf(v), map[visitKey]bool{})
+}
+
+// visitKey 标识正在转换的指针、切片或map，再次遇到说明值引用了自身
+type visitKey struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+func toObject(v reflect.Value, visiting map[visitKey]bool) (object.Object, error) {
+	if v.Type().Implements(objectType) {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+			return evaluator.NULL, nil
+		}
+		return v.Interface().(object.Object), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map:
+		if !v.IsNil() {
+			key := visitKey{ptr: v.Pointer(), typ: v.Type()}
+			if v.Kind() == reflect.Slice {
+				key.len = v.Len()
+			}
+			if visiting[key] {
+				return nil, fmt.Errorf("cannot convert cyclic Go value of type %s", v.Type())
+			}
+			visiting[key] = true
+			defer delete(visiting, key)
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return evaluator.TRUE, nil
+		}
+		return evaluator.FALSE, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("cannot convert %d to INTEGER: value out of range", v.Uint())
+		}
+		return &object.Integer{Value: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &object.Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &object.String{Value: v.String()}, nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return evaluator.NULL, nil
+		}
+		return toObject(v.Elem(), visiting)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return evaluator.NULL, nil
+		}
+		elements := make([]object.Object, v.Len())
+		for i := range elements {
+			elem, err := toObject(v.Index(i), visiting)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = elem
+		}
+		return &object.Array{Elements: elements}, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return evaluator.NULL, nil
+		}
+		hash := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair, v.Len())}
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := toObject(iter.Key(), visiting)
+			if err != nil {
+				return nil, err
+			}
+			value, err := toObject(iter.Value(), visiting)
+			if err != nil {
+				return nil, err
+			}
+			if err := setPair(hash, key, value); err != nil {
+				return nil, err
+			}
+		}
+		return hash, nil
+	case reflect.Struct:
+		hash := &object.Hash{Pairs: make(map[object.HashKey]object.HashPair)}
+		for _, f := range structFields(v.Type()) {
+			value, err := toObject(v.Field(f.index), visiting)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", f.name, err)
+			}
+			setPair(hash, &object.String{Value: f.name}, value)
+		}
+		return hash, nil
+	}
+	return nil, fmt.Errorf("cannot convert Go value of type %s to a Wizard object", v.Type())
+}
+
+func setPair(hash *object.Hash, key, value object.Object) error {
+	hashable, ok := key.(object.Hashable)
+	if !ok {
+		return fmt.Errorf("unusable as hash key: %s", key.Type())
+	}
+	hash.Pairs[hashable.HashKey()] = object.HashPair{Key: key, Value: value}
+	return nil
+}
+
+// FromObject 把Wizard的值转换成Go的值：INTEGER得到int64，FLOAT得到float64，
+// STRING得到string，BOOLEAN得到bool，NULL得到nil，ARRAY得到[]interface{}，
+// 键都是字符串的HASH得到map[string]interface{}，其他HASH得到map[interface{}]interface{}。
+// 函数等其他对象原样返回，可以传给Interpreter.Call。包含自身的ARRAY或HASH返回错误
+func FromObject(obj object.Object) (interface{}, error) {
+	return fromObject(obj, map[object.Object]bool{})
+}
+
+func fromObject(obj object.Object, visiting map[object.Object]bool) (interface{}, error) {
+	switch obj.(type) {
+	case *object.Array, *object.Hash:
+		if visiting[obj] {
+			return nil, fmt.Errorf("cannot convert cyclic %s", obj.Type())
+		}
+		visiting[obj] = true
+		defer delete(visiting, obj)
+	}
+
+	switch obj := obj.(type) {
+	case nil, *object.Null:
+		return nil, nil
+	case *object.Integer:
+		return obj.Value, nil
+	case *object.Float:
+		return obj.Value, nil
+	case *object.String:
+		return obj.Value, nil
+	case *object.Boolean:
+		return obj.Value, nil
+	case *object.Array:
+		result := make([]interface{}, len(obj.Elements))
+		for i, elem := range obj.Elements {
+			v, err := fromObject(elem, visiting)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = v
+		}
+		return result, nil
+	case *object.Hash:
+		return hashFromObject(obj, visiting)
+	case *object.Error:
+		return nil, &RuntimeError{Err: obj}
+	}
+	return obj, nil
+}
+
+func hashFromObject(hash *object.Hash, visiting map[object.Object]bool) (interface{}, error) {
+	stringKeys := true
+	for _, pair := range hash.Pairs {
+		if _, ok := pair.Key.(*object.String); !ok {
+			stringKeys = false
+			break
+		}
+	}
+
+	if stringKeys {
+		result := make(map[string]interface{}, len(hash.Pairs))
+		for _, pair := range hash.Pairs {
+			v, err := fromObject(pair.Value, visiting)
+			if err != nil {
+				return nil, err
+			}
+			result[pair.Key.(*object.String).Value] = v
+		}
+		return result, nil
+	}
+
+	result := make(map[interface{}]interface{}, len(hash.Pairs))
+	for _, pair := range hash.Pairs {
+		k, err := fromObject(pair.Key, visiting)
+		if err != nil {
+			return nil, err
+		}
+		v, err := fromObject(pair.Value, visiting)
+		if err != nil {
+			return nil, err
+		}
+		result[k] = v
+	}
+	return result, nil
+}
+
+// Decode 把Wizard的值保存到target指向的Go变量中，target必须是非nil的指针。
+// 与encoding/json类似，HASH可以保存到struct中，按字段名或 `wizard` 标签匹配键
+func Decode(obj object.Object, target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("Decode target must be a non-nil pointer, got %T", target)
+	}
+	return decode(obj, v.Elem())
+}
+
+func decode(obj object.Object, v reflect.Value) error {
+	if obj == nil {
+		obj = evaluator.NULL
+	}
+	if v.Type().Implements(objectType) && reflect.TypeOf(obj).AssignableTo(v.Type()) {
+		v.Set(reflect.ValueOf(obj))
+		return nil
+	}
+	if _, ok := obj.(*object.Null); ok {
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+	}
+
+	mismatch := func() error {
+		return fmt.Errorf("cannot decode %s into Go value of type %s", obj.Type(), v.Type())
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return mismatch()
+		}
+		value, err := FromObject(obj)
+		if err != nil {
+			return err
+		}
+		if value != nil {
+			v.Set(reflect.ValueOf(value))
+		}
+		return nil
+	case reflect.Ptr:
+		elem := reflect.New(v.Type().Elem())
+		if err := decode(obj, elem.Elem()); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	case reflect.Bool:
+		b, ok := obj.(*object.Boolean)
+		if !ok {
+			return mismatch()
+		}
+		v.SetBool(b.Value)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := obj.(*object.Integer)
+		if !ok {
+			return mismatch()
+		}
+		if v.OverflowInt(i.Value) {
+			return fmt.Errorf("cannot decode %d into %s: value out of range", i.Value, v.Type())
+		}
+		v.SetInt(i.Value)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok := obj.(*object.Integer)
+		if !ok {
+			return mismatch()
+		}
+		if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+			return fmt.Errorf("cannot decode %d into %s: value out of range", i.Value, v.Type())
+		}
+		v.SetUint(uint64(i.Value))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		switch n := obj.(type) {
+		case *object.Float:
+			v.SetFloat(n.Value)
+		case *object.Integer:
+			v.SetFloat(float64(n.Value))
+		default:
+			return mismatch()
+		}
+		return nil
+	case reflect.String:
+		s, ok := obj.(*object.String)
+		if !ok {
+			return mismatch()
+		}
+		v.SetString(s.Value)
+		return nil
+	case reflect.Slice, reflect.Array:
+		arr, ok := obj.(*object.Array)
+		if !ok {
+			return mismatch()
+		}
+		if v.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(v.Type(), len(arr.Elements), len(arr.Elements)))
+		} else if v.Len() != len(arr.Elements) {
+			return fmt.Errorf("cannot decode ARRAY of length %d into %s", len(arr.Elements), v.Type())
+		}
+		for i, elem := range arr.Elements {
+			if err := decode(elem, v.Index(i)); err != nil {
+				return fmt.Errorf("index %d: %w", i, err)
+			}
+		}
+		return nil
+	case reflect.Map:
+		hash, ok := obj.(*object.Hash)
+		if !ok {
+			return mismatch()
+		}
+		m := reflect.MakeMapWithSize(v.Type(), len(hash.Pairs))
+		for _, pair := range hash.Pairs {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := decode(pair.Key, key); err != nil {
+				return err
+			}
+			value := reflect.New(v.Type().Elem()).Elem()
+			if err := decode(pair.Value, value); err != nil {
+				return fmt.Errorf("key %s: %w", pair.Key.Inspect(), err)
+			}
+			m.SetMapIndex(key, value)
+		}
+		v.Set(m)
+		return nil
+	case reflect.Struct:
+		hash, ok := obj.(*object.Hash)
+		if !ok {
+			return mismatch()
+		}
+		for _, f := range structFields(v.Type()) {
+			key := &object.String{Value: f.name}
+			pair, ok := hash.Pairs[key.HashKey()]
+			if !ok {
+				continue //缺少的字段保持原值
+			}
+			if err := decode(pair.Value, v.Field(f.index)); err != nil {
+				return fmt.Errorf("field %s: %w", f.name, err)
+			}
+		}
+		return nil
+	}
+	return mismatch()
+}
+
+type structField struct {
+	name  string //在HASH中对应的键
+	index int
+}
+
+// structFields 返回struct中需要转换的导出字段
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Name
+		if tag := f.Tag.Get("wizard"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			name, _, _ = strings.Cut(tag, ",")
+		}
+		fields = append(fields, structField{name: name, index: i})
+	}
+	return fields
+}
@@ -0,0 +1,231 @@
+package interpreter
+
+import (
+	"reflect"
+	"testing"
+
+	"my.com/myfile/object"
+)
+
+type point struct {
+	X, Y   int
+	Label  string `wizard:"label"`
+	Hidden bool   `wizard:"-"`
+	secret int
+}
+
+func TestToObject(t *testing.T) {
+	var nilPtr *point
+	n := 7
+
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{nil, "null"},
+		{true, "true"},
+		{42, "42"},
+		{int8(-3), "-3"},
+		{uint16(9), "9"},
+		{1.5, "1.5"},
+		{float32(2), "2.0"},
+		{"hi", "hi"},
+		{[]int{1, 2}, "[1, 2]"},
+		{[2]string{"a", "b"}, "[a, b]"},
+		{[]interface{}{1, "x", nil}, "[1, x, null]"},
+		{map[string]int{"a": 1}, "{a: 1}"},
+		{map[int]bool{2: false}, "{2: false}"},
+		{point{X: 1, Y: 2, Label: "p", Hidden: true, secret: 3}, ""},
+		{nilPtr, "null"},
+		{&n, "7"},
+		{&object.String{Value: "obj"}, "obj"},
+	}
+
+	for _, tt := range tests {
+		obj, err := ToObject(tt.input)
+		if err != nil {
+			t.Errorf("ToObject(%#v): unexpected error %v", tt.input, err)
+			continue
+		}
+		if tt.expected != "" && obj.Inspect() != tt.expected {
+			t.Errorf("ToObject(%#v) = %s, want %s", tt.input, obj.Inspect(), tt.expected)
+		}
+	}
+
+	obj, _ := ToObject(point{X: 1, Y: 2, Label: "p", Hidden: true})
+	hash := obj.(*object.Hash)
+	if len(hash.Pairs) != 3 {
+		t.Errorf("struct should have 3 pairs, got %s", hash.Inspect())
+	}
+	for key, want := range map[string]string{"X": "1", "Y": "2", "label": "p"} {
+		pair, ok := hash.Pairs[(&object.String{Value: key}).HashKey()]
+		if !ok || pair.Value.Inspect() != want {
+			t.Errorf("field %s: got %v, want %s", key, pair.Value, want)
+		}
+	}
+}
+
+type node struct {
+	Next *node
+}
+
+func TestToObjectErrors(t *testing.T) {
+	cyclic := []interface{}{1}
+	cyclic[0] = cyclic
+	loop := &node{}
+	loop.Next = loop
+	self := map[string]interface{}{}
+	self["self"] = self
+
+	tests := []interface{}{
+		make(chan int),
+		func() {},
+		uint64(1 << 63),
+		map[[2]int]int{{1, 2}: 3},
+		[]interface{}{1, func() {}},
+		cyclic,
+		loop,
+		self,
+	}
+	for _, input := range tests {
+		if _, err := ToObject(input); err == nil {
+			t.Errorf("ToObject(%T): expected error", input)
+		}
+	}
+}
+
+func TestFromObject(t *testing.T) {
+	in := New()
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1", int64(1)},
+		{"1.5", 1.5},
+		{`"s"`, "s"},
+		{"true", true},
+		{"if (false) { 1 }", nil},
+		{`[1, "a", [true]]`, []interface{}{int64(1), "a", []interface{}{true}}},
+		{`{"a": 1, "b": [2]}`, map[string]interface{}{"a": int64(1), "b": []interface{}{int64(2)}}},
+		{`{1: "one", true: 2}`, map[interface{}]interface{}{int64(1): "one", true: int64(2)}},
+	}
+
+	for _, tt := range tests {
+		obj, err := in.RunString(tt.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := FromObject(obj)
+		if err != nil {
+			t.Errorf("FromObject(%s): unexpected error %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("FromObject(%s) = %#v, want %#v", tt.input, got, tt.expected)
+		}
+	}
+
+	fn, _ := in.RunString("fn(x) { x }")
+	if got, _ := FromObject(fn); got != fn {
+		t.Errorf("functions should be returned as is, got %#v", got)
+	}
+}
+
+func TestCyclicValues(t *testing.T) {
+	shared := []int{1}
+	obj, err := ToObject([]interface{}{shared, shared})
+	if err != nil {
+		t.Fatalf("shared values are not cycles: %v", err)
+	}
+	if obj.Inspect() != "[[1], [1]]" {
+		t.Errorf("got %s", obj.Inspect())
+	}
+
+	in := New()
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let a = [1]; a[0] = a; a", "cannot convert cyclic ARRAY"},
+		{`let h = {}; h["l"] = [h]; h`, "cannot convert cyclic HASH"},
+	}
+	for _, tt := range tests {
+		obj, err := in.RunString(tt.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := FromObject(obj); err == nil || err.Error() != tt.expected {
+			t.Errorf("FromObject(%s): expected error %q, got %v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	in := New()
+	run := func(src string) object.Object {
+		obj, err := in.RunString(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return obj
+	}
+
+	var p point
+	p.Hidden = true
+	if err := Decode(run(`{"X": 3, "label": "origin", "Hidden": false}`), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p != (point{X: 3, Label: "origin", Hidden: true}) {
+		t.Errorf("wrong struct. got=%+v", p)
+	}
+
+	var pts []*point
+	run("let null_value = if (false) { 1 };")
+	if err := Decode(run(`[{"X": 1}, null_value]`), &pts); err != nil {
+		t.Fatal(err)
+	}
+	if len(pts) != 2 || pts[0].X != 1 || pts[1] != nil {
+		t.Errorf("wrong slice. got=%+v", pts)
+	}
+
+	var m map[string][]float64
+	if err := Decode(run(`{"a": [1, 2.5]}`), &m); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, map[string][]float64{"a": {1, 2.5}}) {
+		t.Errorf("wrong map. got=%v", m)
+	}
+
+	var any interface{}
+	if err := Decode(run(`[1]`), &any); err != nil || !reflect.DeepEqual(any, []interface{}{int64(1)}) {
+		t.Errorf("wrong interface value. got=%#v, err=%v", any, err)
+	}
+
+	var obj object.Object
+	if err := Decode(run(`"x"`), &obj); err != nil || obj.Inspect() != "x" {
+		t.Errorf("wrong object. got=%v, err=%v", obj, err)
+	}
+
+	var arr [2]int
+	var small int8
+	var u uint
+	var s string
+	errorTests := []struct {
+		input  string
+		target interface{}
+	}{
+		{`"x"`, &small},
+		{`300`, &small},
+		{`-1`, &u},
+		{`1`, &s},
+		{`[1, 2, 3]`, &arr},
+		{`{"X": "wrong"}`, &p},
+		{`1`, p},
+		{`1`, nil},
+	}
+	for _, tt := range errorTests {
+		if err := Decode(run(tt.input), tt.target); err == nil {
+			t.Errorf("Decode(%s, %T): expected error", tt.input, tt.target)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Package interpreter 提供在Go程序中嵌入Wizard的接口：
+// 执行源码、读写全局变量、注册Go实现的内置函数以及从Go调用Wizard函数
+package interpreter
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"my.com/myfile/evaluator"
+	"my.com/myfile/lexer"
+	"my.com/myfile/object"
+	"my.com/myfile/parser"
+)
+
+// Interpreter 一个独立的Wizard解释器，每个Interpreter有自己的全局环境和输入输出，
+// 多次调用RunString时共享同一个全局环境
+type Interpreter struct {
+	runtime *object.Runtime
+	env     *object.Environment
+}
+
+// New 创建一个使用进程标准输入输出的解释器，可以通过Runtime修改
+func New() *Interpreter {
+	rt := object.NewRuntime()
+	return &Interpreter{runtime: rt, env: object.NewEnvironmentWithRuntime(rt)}
+}
+
+// Runtime 返回脚本使用的输入输出和执行限制，修改它的字段可以捕获或重定向脚本的输出，
+// 或者通过Context和Limits避免脚本无限运行
+func (in *Interpreter) Runtime() *object.Runtime {
+	return in.runtime
+}
+
+// ParseError 源码中有语法错误，Diagnostics中的每个错误可以通过Excerpt得到出错的源码片段
+type ParseError struct {
+	Errors      []string
+	Diagnostics []*lexer.Error
+}
+
+func (e *ParseError) Error() string {
+	return "parser errors:\n\t" + strings.Join(e.Errors, "\n\t")
+}
+
+// RuntimeError 求值时产生的错误，Err是脚本中得到的错误对象，
+// Err.Stack是错误经过的函数调用，Err.Traceback()返回可以打印的调用栈
+type RuntimeError struct {
+	Err *object.Error
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Err.Pos.IsValid() {
+		return e.Err.Pos.String() + ": " + e.Err.Message
+	}
+	return e.Err.Message
+}
+
+// RunString 执行一段源码，返回最后一个表达式的值
+func (in *Interpreter) RunString(src string) (object.Object, error) {
+	return in.run("", src)
+}
+
+// RunFile 执行一个文件，错误信息中的位置带有文件名
+func (in *Interpreter) RunFile(filename string) (object.Object, error) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return in.run(filename, string(src))
+}
+
+func (in *Interpreter) run(filename, src string) (object.Object, error) {
+	p := parser.New(lexer.NewFile(filename, src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &ParseError{Errors: p.Errors(), Diagnostics: p.Diagnostics()}
+	}
+	return result(evaluator.Eval(program, in.env))
+}
+
+// SetGlobal 把Go的值转换成Wizard的值，绑定到全局变量name上
+func (in *Interpreter) SetGlobal(name string, value interface{}) error {
+	obj, err := ToObject(value)
+	if err != nil {
+		return err
+	}
+	in.env.Set(name, obj)
+	return nil
+}
+
+// GetGlobal 返回全局变量name的值
+func (in *Interpreter) GetGlobal(name string) (object.Object, bool) {
+	return in.env.Get(name)
+}
+
+// RegisterBuiltin 注册一个Go实现的函数，只在这个解释器中可见。
+// 与内置函数同名时覆盖内置函数
+func (in *Interpreter) RegisterBuiltin(name string, fn object.BuiltinFunction) {
+	in.env.Set(name, &object.Builtin{Fn: fn})
+}
+
+// Call 调用Wizard函数或内置函数fn，args先用ToObject转换成Wizard的值
+func (in *Interpreter) Call(fn object.Object, args ...interface{}) (object.Object, error) {
+	objs := make([]object.Object, len(args))
+	for i, arg := range args {
+		obj, err := ToObject(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		objs[i] = obj
+	}
+	return result(evaluator.Apply(fn, objs, in.runtime))
+}
+
+// result 把求值结果中的*object.Error转换成Go的error，nil转换成NULL
+func result(obj object.Object) (object.Object, error) {
+	if errObj, ok := obj.(*object.Error); ok {
+		return nil, &RuntimeError{Err: errObj}
+	}
+	if obj == nil {
+		return evaluator.NULL, nil
+	}
+	return obj, nil
+}
@@ -0,0 +1,218 @@
+package interpreter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"my.com/myfile/object"
+)
+
+func TestRunString(t *testing.T) {
+	in := New()
+	var out bytes.Buffer
+	in.Runtime().Stdout = &out
+
+	result, err := in.RunString(`let add = fn(a, b) { a + b }; puts("hi"); add(1, 2)`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Inspect() != "3" {
+		t.Errorf("wrong result. got=%s", result.Inspect())
+	}
+	if out.String() != "hi" {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+
+	// 多次调用共享全局环境
+	result, err = in.RunString("add(2, 3)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Inspect() != "5" {
+		t.Errorf("wrong result. got=%s", result.Inspect())
+	}
+
+	result, err = in.RunString("let x = 1;")
+	if err != nil || result.Type() != object.NULL_OBJ {
+		t.Errorf("expected NULL, got %v, %v", result, err)
+	}
+}
+
+func TestRunStringErrors(t *testing.T) {
+	in := New()
+
+	_, err := in.RunString("let = 1;")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || len(parseErr.Errors) == 0 {
+		t.Fatalf("expected *ParseError, got %T (%v)", err, err)
+	}
+
+	_, err = in.RunString("1;\nunknown")
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected *RuntimeError, got %T (%v)", err, err)
+	}
+	if err.Error() != "2:1: identifier not found: unknown" {
+		t.Errorf("wrong error message. got=%q", err.Error())
+	}
+}
+
+func TestRunFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "script.wz")
+	os.WriteFile(filename, []byte("let a = 2;\na * 21\n1 / 0"), 0644)
+
+	_, err := New().RunFile(filename)
+	if err == nil || err.Error() != filename+":3:3: division by zero: 1 / 0" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+
+	if _, err := New().RunFile(filepath.Join(t.TempDir(), "missing.wz")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected os.ErrNotExist, got %v", err)
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	in := New()
+	if err := in.SetGlobal("config", map[string]interface{}{"name": "wizard", "retries": 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := in.SetGlobal("bad", make(chan int)); err == nil {
+		t.Errorf("expected error for unsupported type")
+	}
+
+	if _, err := in.RunString(`let greeting = config["name"] + "!"; let n = config["retries"] * 2;`); err != nil {
+		t.Fatal(err)
+	}
+
+	greeting, ok := in.GetGlobal("greeting")
+	if !ok || greeting.Inspect() != "wizard!" {
+		t.Errorf("wrong greeting. got=%v", greeting)
+	}
+	var n int
+	obj, _ := in.GetGlobal("n")
+	if err := Decode(obj, &n); err != nil || n != 6 {
+		t.Errorf("wrong n. got=%d, err=%v", n, err)
+	}
+	if _, ok := in.GetGlobal("missing"); ok {
+		t.Errorf("missing global should not be found")
+	}
+}
+
+func TestRegisterBuiltin(t *testing.T) {
+	in := New()
+	var logged []string
+	in.RegisterBuiltin("log", func(rt *object.Runtime, args ...object.Object) object.Object {
+		for _, arg := range args {
+			logged = append(logged, arg.Inspect())
+		}
+		return nil
+	})
+	in.RegisterBuiltin("fail", func(rt *object.Runtime, args ...object.Object) object.Object {
+		return &object.Error{Message: "failed on purpose"}
+	})
+
+	result, err := in.RunString(`log("a", 1); log([2]);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Type() != object.NULL_OBJ {
+		t.Errorf("expected NULL, got %s", result.Inspect())
+	}
+	if strings.Join(logged, ",") != "a,1,[2]" {
+		t.Errorf("wrong log. got=%q", logged)
+	}
+
+	_, err = in.RunString("fail()")
+	if err == nil || err.Error() != "1:1: failed on purpose" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+
+	// 其他解释器看不到注册的函数
+	if _, err := New().RunString("log(1)"); err == nil {
+		t.Errorf("builtin registered on one interpreter leaked into another")
+	}
+}
+
+func TestCall(t *testing.T) {
+	in := New()
+	if _, err := in.RunString(`let scale = fn(xs, k) { [first(xs) * k, last(xs) * k] };`); err != nil {
+		t.Fatal(err)
+	}
+	scale, _ := in.GetGlobal("scale")
+
+	result, err := in.Call(scale, []int{2, 5}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	if err := Decode(result, &got); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "[6 15]" {
+		t.Errorf("wrong result. got=%v", got)
+	}
+
+	if _, err := in.Call(scale, 1); err == nil || !strings.Contains(err.Error(), "wrong number of arguments") {
+		t.Errorf("expected arity error, got %v", err)
+	}
+	if _, err := in.Call(scale, make(chan int), 1); err == nil || !strings.HasPrefix(err.Error(), "argument 1:") {
+		t.Errorf("expected conversion error, got %v", err)
+	}
+	if _, err := in.Call(&object.Integer{Value: 1}); err == nil || !strings.Contains(err.Error(), "not a function") {
+		t.Errorf("expected not a function error, got %v", err)
+	}
+
+	length, _ := in.RunString("len")
+	if result, err := in.Call(length, "hello"); err != nil || result.Inspect() != "5" {
+		t.Errorf("calling builtin: got %v, %v", result, err)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	in := New()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	in.Runtime().Context = ctx
+
+	_, err := in.RunString("while (true) {}")
+	if err == nil || !strings.HasSuffix(err.Error(), "execution timed out") {
+		t.Errorf("expected timeout, got %v", err)
+	}
+
+	in = New()
+	in.Runtime().Limits = object.Limits{MaxSteps: 10000}
+	if _, err := in.RunString("let f = fn(n) { if (n > 0) { f(n - 1) } }; f(100)"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	loop, _ := in.RunString("fn() { while (true) {} }")
+	if _, err := in.Call(loop); err == nil || !strings.HasSuffix(err.Error(), "step limit exceeded") {
+		t.Errorf("expected step limit error from Call, got %v", err)
+	}
+}
+
+func TestRuntimeErrorStack(t *testing.T) {
+	in := New()
+	_, err := in.RunString("let check = fn(x) { if (x < 0) { 1 / 0 } x };\nlet run = fn() { check(-1) };\nrun()")
+
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected *RuntimeError, got %v", err)
+	}
+	stack := runtimeErr.Err.Stack
+	if len(stack) != 2 || stack[0].Function != "check" || stack[0].Pos.Line != 2 || stack[1].Function != "run" || stack[1].Pos.Line != 3 {
+		t.Errorf("wrong stack. got=%+v", stack)
+	}
+
+	check, _ := in.GetGlobal("check")
+	_, err = in.Call(check, -1)
+	if !errors.As(err, &runtimeErr) || len(runtimeErr.Err.Stack) != 1 || runtimeErr.Err.Stack[0].Pos.IsValid() {
+		t.Errorf("call from Go should record a frame without position. got=%+v", runtimeErr.Err.Stack)
+	}
+}
@@ -0,0 +1,72 @@
+package lexer
+
+import (
+	"strings"
+
+	"my.com/myfile/token"
+)
+
+// Error 词法或语法错误，除了位置和信息之外还保存了出错的那一行源码，用于显示源码片段
+type Error struct {
+	Pos    token.Position
+	End    token.Position //出错的token之后的位置，与Pos不在同一行或者无效时只标出一个字符
+	Msg    string
+	Source string //Pos所在的那一行源码，不包括换行符
+}
+
+// Error 返回 file:line:col: msg 形式的错误信息
+func (e *Error) Error() string {
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + e.Msg
+	}
+	return e.Msg
+}
+
+// Excerpt 返回两行文本：出错的那一行源码，以及在出错的token下面画出的^，例如
+//
+//	if (x { return 1 }
+//	      ^
+//
+// 没有位置信息时返回空字符串
+func (e *Error) Excerpt() string {
+	if !e.Pos.IsValid() {
+		return ""
+	}
+
+	var marker strings.Builder
+	column := 1
+	for _, ch := range e.Source {
+		if column >= e.Pos.Column {
+			break
+		}
+		if ch == '\t' { //保留制表符，使^与源码对齐
+			marker.WriteByte('\t')
+		} else {
+			marker.WriteByte(' ')
+		}
+		column++
+	}
+
+	width := 1
+	if e.End.Line == e.Pos.Line && e.End.Column > e.Pos.Column {
+		width = e.End.Column - e.Pos.Column
+	}
+	marker.WriteString(strings.Repeat("^", width))
+
+	return e.Source + "\n" + marker.String()
+}
+
+// SourceLine 返回pos所在的那一行源码
+func (l *Lexer) SourceLine(pos token.Position) string {
+	if pos.Offset < 0 || pos.Offset > len(l.input) {
+		return ""
+	}
+	start := strings.LastIndexByte(l.input[:pos.Offset], '\n') + 1
+	end := strings.IndexByte(l.input[pos.Offset:], '\n')
+	if end < 0 {
+		end = len(l.input)
+	} else {
+		end += pos.Offset
+	}
+	return strings.TrimSuffix(l.input[start:end], "\r")
+}
@@ -3,27 +3,86 @@ package lexer
 
 import (
 	"bytes"
+	"fmt"
 
 	"my.com/myfile/token"
 )
 
 type Lexer struct { //Lexer的主体
 	input        string //所有int类型的成员都被自动初始化为0
+	filename     string //源文件名，用于错误信息
 	position     int    //正在获取的字符的位置
 	readPosition int    //需要读取的字符的位置
 	ch           byte   //正在处理的字符
+	line         int    //ch所在的行，从1开始
+	column       int    //ch所在的列，从1开始
+
+	comments     []token.Token //跳过的注释，按出现顺序保存
+	errors       []*Error      //词法错误，例如未结束的块注释
+	unterminated bool          //输入在字符串或块注释中间结束
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input} //将input作为Lexer结构体的input初始化l
-	l.readChar()              //next操作，使得position=0,readposition=1
-	return l                  //返回一个Lexer结构体的指针
+	return NewFile("", input)
+}
+
+// NewFile 创建一个词法分析器，filename会记录在每个token的位置中
+func NewFile(filename, input string) *Lexer {
+	l := &Lexer{input: input, filename: filename, line: 1} //将input作为Lexer结构体的input初始化l
+	l.readChar()                                           //next操作，使得position=0,readposition=1
+	return l                                               //返回一个Lexer结构体的指针
 }
 
 func (l *Lexer) NextToken() token.Token { //受parser.nextToken调用
-	var tok token.Token
+	l.skipWhitespaceAndComments() //跳过空格，制表符，换行符和注释
+
+	pos := l.pos()
+	tok := l.readToken()
+	tok.Pos = pos
+	tok.End = l.pos()
+	return tok
+}
+
+// Comments 返回目前为止读到的所有注释，Type为token.COMMENT，Literal包含注释符号
+func (l *Lexer) Comments() []token.Token {
+	return l.comments
+}
+
+// Unterminated 返回输入是否在字符串或块注释的中间结束，REPL据此判断是否需要继续读取
+func (l *Lexer) Unterminated() bool {
+	return l.unterminated
+}
 
-	l.skipWhitespace() //跳过空格，制表符，换行符
+// Errors 返回词法分析过程中发现的错误
+func (l *Lexer) Errors() []string {
+	msgs := make([]string, len(l.errors))
+	for i, err := range l.errors {
+		msgs[i] = err.Error()
+	}
+	return msgs
+}
+
+// Diagnostics 与Errors相同，但是每个错误带有出错的那一行源码
+func (l *Lexer) Diagnostics() []*Error {
+	return l.errors
+}
+
+func (l *Lexer) errorf(pos token.Position, format string, a ...interface{}) {
+	l.errors = append(l.errors, &Error{Pos: pos, Msg: fmt.Sprintf(format, a...), Source: l.SourceLine(pos)})
+}
+
+// pos 返回当前字符ch的位置
+func (l *Lexer) pos() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
+}
+
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
 
 	switch l.ch { //运算符判断
 	case '=':
@@ -36,12 +95,25 @@ func (l *Lexer) NextToken() token.Token { //受parser.nextToken调用
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.readTwoCharToken(token.PLUS_ASSIGN)
+		} else {
+			tok = newToken(token.PLUS, l.ch)
+		}
 	case '"':
+		pos := l.pos()
 		tok.Type = token.STRING
 		tok.Literal = l.readString()
+		if l.ch == 0 {
+			l.unterminated = true
+			l.errorf(pos, "unterminated string")
+		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.readTwoCharToken(token.MINUS_ASSIGN)
+		} else {
+			tok = newToken(token.MINUS, l.ch)
+		}
 	case '!':
 		if l.peekChar() == '=' {
 			ch := l.ch
@@ -52,15 +124,27 @@ func (l *Lexer) NextToken() token.Token { //受parser.nextToken调用
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.readTwoCharToken(token.SLASH_ASSIGN)
+		} else {
+			tok = newToken(token.SLASH, l.ch)
+		}
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.readTwoCharToken(token.ASTERISK_ASSIGN)
+		} else if l.peekChar() == '*' {
+			tok = l.readTwoCharToken(token.POWER)
+		} else {
+			tok = newToken(token.ASTERISK, l.ch)
+		}
 	case '<':
 		if l.peekChar() == '=' {
 			ch := l.ch
 			l.readChar()
 			literal := string(ch) + string(l.ch)
 			tok = token.Token{Type: token.LE, Literal: literal} //LE就是<=
+		} else if l.peekChar() == '<' {
+			tok = l.readTwoCharToken(token.SHL)
 		} else {
 			tok = newToken(token.LT, l.ch)
 		}
@@ -70,9 +154,39 @@ func (l *Lexer) NextToken() token.Token { //受parser.nextToken调用
 			l.readChar()
 			literal := string(ch) + string(l.ch)
 			tok = token.Token{Type: token.GE, Literal: literal} //GE就是>=
+		} else if l.peekChar() == '>' {
+			tok = l.readTwoCharToken(token.SHR)
 		} else {
 			tok = newToken(token.GT, l.ch)
 		}
+	case '&':
+		if l.peekChar() == '&' {
+			tok = l.readTwoCharToken(token.AND)
+		} else {
+			tok = newToken(token.BIT_AND, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			tok = l.readTwoCharToken(token.OR)
+		} else {
+			tok = newToken(token.BIT_OR, l.ch)
+		}
+	case '^':
+		tok = newToken(token.BIT_XOR, l.ch)
+	case '~':
+		tok = newToken(token.BIT_NOT, l.ch)
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
+	case '.':
+		if l.peekChar() == '.' {
+			tok = l.readTwoCharToken(token.DOTDOT)
+			if l.peekChar() == '=' {
+				l.readChar()
+				tok = token.Token{Type: token.DOTDOT_EQ, Literal: "..="}
+			}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
 	case ':':
@@ -102,13 +216,14 @@ func (l *Lexer) NextToken() token.Token { //受parser.nextToken调用
 		} else if isDigit(l.ch) {
 			tok.Type = token.INT
 			tok.Literal = l.readNumber()
-			if l.ch == '.' {
+			if l.ch == '.' && isDigit(l.peekChar()) { //小数点后必须是数字
 				tok.Type = token.FLOAT
 				tok.Literal += string(l.ch)
 				l.readChar()
 				tok.Literal += l.readNumber()
 			}
-			if l.ch == 'E' || l.ch == 'e' {
+			if (l.ch == 'E' || l.ch == 'e') && l.isExponent() { //指数部分，如1.5e-3
+				tok.Type = token.FLOAT
 				tok.Literal += string(l.ch)
 				l.readChar()
 				if l.ch == '+' || l.ch == '-' {
@@ -133,7 +248,74 @@ func (l *Lexer) skipWhitespace() { //跳过
 	}
 }
 
+func (l *Lexer) skipWhitespaceAndComments() {
+	for {
+		l.skipWhitespace()
+		switch {
+		case l.ch == '/' && l.peekChar() == '/':
+			l.readLineComment()
+		case l.ch == '/' && l.peekChar() == '*':
+			l.readBlockComment()
+		default:
+			return
+		}
+	}
+}
+
+// readLineComment 读取 // 开始到行尾的注释，不包括换行符
+func (l *Lexer) readLineComment() {
+	pos := l.pos()
+	position := l.position
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+	l.addComment(pos, l.input[position:l.position])
+}
+
+// readBlockComment 读取 /* */ 注释，块注释可以嵌套
+func (l *Lexer) readBlockComment() {
+	pos := l.pos()
+	position := l.position
+	depth := 0
+	for {
+		switch {
+		case l.ch == 0:
+			l.unterminated = true
+			l.errorf(pos, "unterminated block comment")
+			l.addComment(pos, l.input[position:l.position])
+			return
+		case l.ch == '/' && l.peekChar() == '*':
+			depth++
+			l.readChar()
+			l.readChar()
+		case l.ch == '*' && l.peekChar() == '/':
+			depth--
+			l.readChar()
+			l.readChar()
+			if depth == 0 {
+				l.addComment(pos, l.input[position:l.position])
+				return
+			}
+		default:
+			l.readChar()
+		}
+	}
+}
+
+func (l *Lexer) addComment(pos token.Position, text string) {
+	l.comments = append(l.comments, token.Token{
+		Type:    token.COMMENT,
+		Literal: text,
+		Pos:     pos,
+		End:     l.pos(),
+	})
+}
+
 func (l *Lexer) readChar() { //next操作
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -141,6 +323,9 @@ func (l *Lexer) readChar() { //next操作
 	}
 	l.position = l.readPosition
 	l.readPosition += 1
+	if l.ch&0xC0 != 0x80 { //UTF-8的后续字节不单独占一列
+		l.column++
+	}
 }
 
 func (l *Lexer) peekChar() byte { //peekChar读取当前字符的后一个字符，如果有则返回进一步判断
@@ -151,6 +336,18 @@ func (l *Lexer) peekChar() byte { //peekChar读取当前字符的后一个字符
 	}
 }
 
+// isExponent 判断当前的e或E后面是否跟着指数，即数字或者带符号的数字
+func (l *Lexer) isExponent() bool {
+	next := l.peekChar()
+	if next == '+' || next == '-' {
+		if l.readPosition+1 >= len(l.input) {
+			return false
+		}
+		next = l.input[l.readPosition+1]
+	}
+	return isDigit(next)
+}
+
 func (l *Lexer) readIdentifier() string { //修改，应该是接受字符数字和下划线
 	position := l.position //保存初始位置
 	for isLetter(l.ch) || isDigit(l.ch) {
@@ -175,6 +372,13 @@ func isDigit(ch byte) bool {
 	return '0' <= ch && ch <= '9'
 }
 
+// readTwoCharToken 读取由当前字符和下一个字符组成的token，例如 +=
+func (l *Lexer) readTwoCharToken(tokenType token.TokenType) token.Token {
+	ch := l.ch
+	l.readChar()
+	return token.Token{Type: tokenType, Literal: string(ch) + string(l.ch)}
+}
+
 func newToken(tokenType token.TokenType, ch byte) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)} //用来处理token的值是一个字符串的情况
 }
@@ -15,7 +15,7 @@ let add = fn(x, y) {
 };
 
 let result = add(five, ten);
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 
 if (5 < 10) {
@@ -106,13 +106,235 @@ if (5 < 10) {
 		{token.NOT_EQ, "!="},
 		{token.INT, "9"},
 		{token.SEMICOLON, ";"},
-		{token.EOF, ""},
 		{token.LBRACKET, "["},
 		{token.INT, "1"},
 		{token.COMMA, ","},
 		{token.INT, "2"},
 		{token.RBRACKET, "]"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestTokenPosition(t *testing.T) {
+	input := "let x = 10;\n  puts(\"中文\", x)"
+
+	tests := []struct {
+		expectedLiteral string
+		expectedPos     string
+		expectedEnd     string
+	}{
+		{"let", "main.wz:1:1", "main.wz:1:4"},
+		{"x", "main.wz:1:5", "main.wz:1:6"},
+		{"=", "main.wz:1:7", "main.wz:1:8"},
+		{"10", "main.wz:1:9", "main.wz:1:11"},
+		{";", "main.wz:1:11", "main.wz:1:12"},
+		{"puts", "main.wz:2:3", "main.wz:2:7"},
+		{"(", "main.wz:2:7", "main.wz:2:8"},
+		{"中文", "main.wz:2:8", "main.wz:2:12"},
+		{",", "main.wz:2:12", "main.wz:2:13"},
+		{"x", "main.wz:2:14", "main.wz:2:15"},
+		{")", "main.wz:2:15", "main.wz:2:16"},
+		{"", "main.wz:2:16", "main.wz:2:17"},
+	}
+
+	l := NewFile("main.wz", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Pos.String() != tt.expectedPos {
+			t.Fatalf("tests[%d] - position wrong. expected=%q, got=%q",
+				i, tt.expectedPos, tok.Pos.String())
+		}
+		if tok.End.String() != tt.expectedEnd {
+			t.Fatalf("tests[%d] - end position wrong. expected=%q, got=%q",
+				i, tt.expectedEnd, tok.End.String())
+		}
+	}
+}
+
+func TestNumber(t *testing.T) {
+	input := `1.5 1.5e-3 2E10 42 3.x 1e 0..10 1.5..2 0..=9`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.FLOAT, "1.5"},
+		{token.FLOAT, "1.5e-3"},
+		{token.FLOAT, "2E10"},
+		{token.INT, "42"},
+		{token.INT, "3"},
+		{token.ILLEGAL, "."},
+		{token.ID, "x"},
+		{token.INT, "1"},
+		{token.ID, "e"},
+		{token.INT, "0"},
+		{token.DOTDOT, ".."},
+		{token.INT, "10"},
+		{token.FLOAT, "1.5"},
+		{token.DOTDOT, ".."},
+		{token.INT, "2"},
+		{token.INT, "0"},
+		{token.DOTDOT_EQ, "..="},
+		{token.INT, "9"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// line comment
+let a = 10 / 2; // trailing
+/* block /* nested */ still comment */ a
+/* unterminated`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.ID, "a"},
+		{token.ASSIGN, "="},
+		{token.INT, "10"},
+		{token.SLASH, "/"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.ID, "a"},
+		{token.EOF, ""},
+	}
+
+	l := NewFile("c.wz", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+
+	expectedComments := []struct {
+		literal string
+		pos     string
+	}{
+		{"// line comment", "c.wz:1:1"},
+		{"// trailing", "c.wz:2:17"},
+		{"/* block /* nested */ still comment */", "c.wz:3:1"},
+		{"/* unterminated", "c.wz:4:1"},
+	}
+
+	comments := l.Comments()
+	if len(comments) != len(expectedComments) {
+		t.Fatalf("wrong number of comments. expected=%d, got=%d",
+			len(expectedComments), len(comments))
+	}
+	for i, ec := range expectedComments {
+		if comments[i].Type != token.COMMENT {
+			t.Errorf("comments[%d] - tokentype wrong. got=%q", i, comments[i].Type)
+		}
+		if comments[i].Literal != ec.literal {
+			t.Errorf("comments[%d] - literal wrong. expected=%q, got=%q",
+				i, ec.literal, comments[i].Literal)
+		}
+		if comments[i].Pos.String() != ec.pos {
+			t.Errorf("comments[%d] - position wrong. expected=%q, got=%q",
+				i, ec.pos, comments[i].Pos.String())
+		}
+	}
+
+	errors := l.Errors()
+	if len(errors) != 1 || errors[0] != "c.wz:4:1: unterminated block comment" {
+		t.Errorf("wrong lexer errors. got=%q", errors)
+	}
+}
+
+func TestOperators(t *testing.T) {
+	input := `a += 1; a -= 1; a *= 2; a /= 2; a && b || c and d or e;
+% ** & | ^ ~ << >> <= >= &&`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.ID, "a"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.ID, "a"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.ID, "a"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.ID, "a"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.ID, "a"},
+		{token.AND, "&&"},
+		{token.ID, "b"},
+		{token.OR, "||"},
+		{token.ID, "c"},
+		{token.AND, "and"},
+		{token.ID, "d"},
+		{token.OR, "or"},
+		{token.ID, "e"},
 		{token.SEMICOLON, ";"},
+		{token.PERCENT, "%"},
+		{token.POWER, "**"},
+		{token.BIT_AND, "&"},
+		{token.BIT_OR, "|"},
+		{token.BIT_XOR, "^"},
+		{token.BIT_NOT, "~"},
+		{token.SHL, "<<"},
+		{token.SHR, ">>"},
+		{token.LE, "<="},
+		{token.GE, ">="},
+		{token.AND, "&&"},
 		{token.EOF, ""},
 	}
 
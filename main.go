@@ -2,17 +2,194 @@ package main
 
 import (
 	"fmt"
-	"my.com/myfile/repl"
+	"io"
 	"os"
 	"os/user"
+	"strings"
+
+	"my.com/myfile/ast"
+	"my.com/myfile/evaluator"
+	"my.com/myfile/lexer"
+	"my.com/myfile/object"
+	"my.com/myfile/parser"
+	"my.com/myfile/repl"
+	"my.com/myfile/vm"
 )
 
+// 进程退出码
+const (
+	exitOK           = 0
+	exitRuntimeError = 1  //求值得到*object.Error
+	exitParseError   = 2  //语法分析阶段收集到错误
+	exitUsage        = 64 //命令行参数错误
+)
+
+const usage = `Usage:
+  wizard                      start the REPL (reads the program from stdin when it is not a terminal)
+  wizard run <file> [args...] run a script file
+  wizard <file> [args...]     same as "wizard run", used by "#!/usr/bin/env wizard"
+  wizard -e <code> [args...]  evaluate code and print the result
+  wizard -                    read the program from stdin
+  wizard bench [flags] [name|file...]
+                              measure the built-in benchmark programs (or the given files)
+                              on each backend; run "wizard bench -h" for the flags
+  wizard -h                   show this help
+
+Options:
+  --vm                        run on the bytecode virtual machine instead of the
+                              tree-walking evaluator (must come before the other arguments)
+`
+
+// evalFunc 执行语法树，evaluator.Eval和vm.Eval的结果相同
+type evalFunc func(program *ast.Program, env *object.Environment) object.Object
+
+func evalTree(program *ast.Program, env *object.Environment) object.Object {
+	return evaluator.Eval(program, env)
+}
+
 func main() {
-	user, err := user.Current()
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run 解析命令行参数并执行相应的操作，返回进程退出码
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	rt := &object.Runtime{Stdin: stdin, Stdout: stdout, Stderr: stderr} //脚本通过rt进行输入输出
+
+	var eval evalFunc = evalTree
+	backend := repl.BackendEval
+	if len(args) > 0 && args[0] == "--vm" { //使用字节码虚拟机执行
+		eval, backend = vm.Eval, repl.BackendVM
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		if isTerminal(stdin) {
+			startRepl(stdin, stdout, backend)
+			return exitOK
+		}
+		return runStdin(nil, rt, eval)
+	}
+
+	switch args[0] {
+	case "-h", "--help", "help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	case "-e":
+		if len(args) < 2 {
+			fmt.Fprintln(stderr, "wizard: -e requires an argument")
+			return exitUsage
+		}
+		return execute("<eval>", args[1], args[2:], true, rt, eval)
+	case "-":
+		return runStdin(args[1:], rt, eval)
+	case "bench":
+		return runBench(args[1:], stdout, stderr)
+	case "run":
+		if len(args) < 2 {
+			fmt.Fprintln(stderr, "wizard: run requires a file name")
+			fmt.Fprint(stderr, usage)
+			return exitUsage
+		}
+		return runFile(args[1], args[2:], rt, eval)
+	}
+
+	if strings.HasPrefix(args[0], "-") {
+		fmt.Fprintf(stderr, "wizard: unknown flag %s\n", args[0])
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	return runFile(args[0], args[1:], rt, eval)
+}
+
+func startRepl(in io.Reader, out io.Writer, backend string) {
+	name := "there"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	fmt.Fprintf(out, "Hello %s! This is the Wizard program language!\n", name)
+	fmt.Fprintf(out, "Feel free to type in commands\n")
+	repl.StartWithBackend(in, out, backend)
+}
+
+func runFile(filename string, scriptArgs []string, rt *object.Runtime, eval evalFunc) int {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(rt.Stderr, "wizard: %v\n", err)
+		return exitUsage
+	}
+	return execute(filename, string(src), scriptArgs, false, rt, eval)
+}
+
+func runStdin(scriptArgs []string, rt *object.Runtime, eval evalFunc) int {
+	src, err := io.ReadAll(rt.Stdin)
+	if err != nil {
+		fmt.Fprintf(rt.Stderr, "wizard: %v\n", err)
+		return exitUsage
+	}
+	return execute("<stdin>", string(src), scriptArgs, false, rt, eval)
+}
+
+// execute 对源码做词法分析、语法分析并求值。
+// printResult为true时(即 -e)，打印最后一个表达式的值
+func execute(name, src string, scriptArgs []string, printResult bool, rt *object.Runtime, eval evalFunc) int {
+	l := lexer.NewFile(name, stripShebang(src))
+	p := parser.New(l)
+
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		fmt.Fprintln(rt.Stderr, "parser errors:")
+		for _, err := range p.Diagnostics() {
+			fmt.Fprintf(rt.Stderr, "\t%s\n", err)
+			if excerpt := err.Excerpt(); excerpt != "" {
+				fmt.Fprintf(rt.Stderr, "\t%s\n", strings.ReplaceAll(excerpt, "\n", "\n\t"))
+			}
+		}
+		return exitParseError
+	}
+
+	env := object.NewEnvironmentWithRuntime(rt)
+	env.Set("args", newArgsArray(scriptArgs))
+
+	evaluated := eval(program, env)
+	if errObj, ok := evaluated.(*object.Error); ok {
+		fmt.Fprintln(rt.Stderr, errObj.Inspect())
+		fmt.Fprint(rt.Stderr, errObj.Traceback())
+		return exitRuntimeError
+	}
+	if printResult && evaluated != nil && evaluated != evaluator.NULL {
+		fmt.Fprintln(rt.Stdout, evaluated.Inspect())
+	}
+	return exitOK
+}
+
+// stripShebang 去掉脚本第一行的 #! 解释器声明，保留换行使行号不变
+func stripShebang(src string) string {
+	if !strings.HasPrefix(src, "#!") {
+		return src
+	}
+	if i := strings.IndexByte(src, '\n'); i >= 0 {
+		return src[i:]
+	}
+	return ""
+}
+
+// newArgsArray 把脚本参数转换成Wizard的字符串数组，脚本中通过args访问
+func newArgsArray(scriptArgs []string) *object.Array {
+	elements := make([]object.Object, len(scriptArgs))
+	for i, arg := range scriptArgs {
+		elements[i] = &object.String{Value: arg}
+	}
+	return &object.Array{Elements: elements}
+}
+
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
 	if err != nil {
-		panic(err)
+		return false
 	}
-	fmt.Printf("Hello %s! This is the Wizard program language!\n", user.Username)
-	fmt.Printf("Feel free to type in commands\n")
-	repl.Start(os.Stdin, os.Stdout)
+	return fi.Mode()&os.ModeCharDevice != 0
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, src string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	script := write("script.wz", "puts(length(args), args)\n")
+	shebang := write("shebang.wz", "#!/usr/bin/env wizard\nputs(\"ok\")\n")
+	shebangError := write("shebang_error.wz", "#!/usr/bin/env wizard\nlet = 1\n")
+	failing := write("failing.wz", "let f = fn() { 1 + true };\nf()\n")
+
+	tests := []struct {
+		name   string
+		args   []string
+		stdin  string
+		code   int
+		stdout string //标准输出的全部内容
+		stderr string //标准错误中应该包含的内容
+	}{
+		{"run file", []string{"run", script, "a", "b"}, "", exitOK, "2[a, b]", ""},
+		{"file without run", []string{script}, "", exitOK, "0[]", ""},
+		{"vm file", []string{"--vm", "run", script, "a"}, "", exitOK, "1[a]", ""},
+		{"eval", []string{"-e", "1 + 2"}, "", exitOK, "3\n", ""},
+		{"eval args", []string{"-e", "args[0]", "x"}, "", exitOK, "x\n", ""},
+		{"eval null", []string{"-e", "let a = 1;"}, "", exitOK, "", ""},
+		{"vm eval", []string{"--vm", "-e", "[1, 2][-1]"}, "", exitOK, "2\n", ""},
+		{"stdin", []string{"-", "a"}, "puts(args)", exitOK, "[a]", ""},
+		{"stdin without args", nil, "puts(1 + 1)", exitOK, "2", ""},
+		{"shebang", []string{shebang}, "", exitOK, "ok", ""},
+		{"shebang keeps line numbers", []string{shebangError}, "", exitParseError, "", "shebang_error.wz:2:5: expected identifier"},
+		{"help", []string{"-h"}, "", exitOK, usage, ""},
+
+		{"runtime error", []string{failing}, "", exitRuntimeError, "", "failing.wz:1:18: type mismatch: INTEGER + BOOLEAN"},
+		{"runtime error in eval", []string{"-e", "throw 1"}, "", exitRuntimeError, "", "<eval>:1:1"},
+		{"runtime error on stdin", []string{"-"}, "1 / 0", exitRuntimeError, "", "<stdin>:1:"},
+		{"parse error", []string{"-e", "let x = ;"}, "", exitParseError, "", "parser errors:\n\t<eval>:1:9: expected expression"},
+		{"parse error on stdin", []string{"-"}, "if (true) {", exitParseError, "", `expected "}", found end of input`},
+
+		{"eval without code", []string{"-e"}, "", exitUsage, "", "-e requires an argument"},
+		{"run without file", []string{"run"}, "", exitUsage, "", "run requires a file name"},
+		{"unknown flag", []string{"--bogus"}, "", exitUsage, "", "unknown flag --bogus"},
+		{"missing file", []string{filepath.Join(dir, "missing.wz")}, "", exitUsage, "", "missing.wz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+			if code != tt.code {
+				t.Errorf("exit code = %d, want %d (stderr=%q)", code, tt.code, stderr.String())
+			}
+			if stdout.String() != tt.stdout {
+				t.Errorf("stdout = %q, want %q", stdout.String(), tt.stdout)
+			}
+			if tt.stderr == "" && stderr.Len() != 0 {
+				t.Errorf("unexpected stderr %q", stderr.String())
+			}
+			if !strings.Contains(stderr.String(), tt.stderr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr.String(), tt.stderr)
+			}
+		})
+	}
+}
+
+func TestStripShebang(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"#!/usr/bin/env wizard\nputs(1)\n", "\nputs(1)\n"},
+		{"#!/usr/bin/env wizard", ""},
+		{"puts(1)\n#!", "puts(1)\n#!"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := stripShebang(tt.input); got != tt.expected {
+			t.Errorf("stripShebang(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
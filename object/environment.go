@@ -1,19 +1,32 @@
 package object //可以使用object.go中定义的Object接口
 
+import "sort"
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
-	env := NewEnvironment()
-	env.outer = outer
-	return env
+	s := make(map[string]Object)
+	return &Environment{store: s, outer: outer, runtime: outer.runtime}
 }
 
+// NewEnvironment 创建一个顶层环境，使用进程的标准输入输出并且没有执行限制
 func NewEnvironment() *Environment {
+	return NewEnvironmentWithRuntime(NewRuntime())
+}
+
+// NewEnvironmentWithRuntime 创建一个顶层环境，在其中求值的代码通过rt进行输入输出
+func NewEnvironmentWithRuntime(rt *Runtime) *Environment {
 	s := make(map[string]Object)
-	return &Environment{store: s, outer: nil}
+	return &Environment{store: s, outer: nil, runtime: rt}
 }
 
 type Environment struct { //使用链表的结构来存储变量，使用Object接口来表示变量
-	store map[string]Object
-	outer *Environment
+	store   map[string]Object
+	outer   *Environment
+	runtime *Runtime //内层环境与外层环境共享同一个Runtime
+}
+
+// Runtime 返回这个环境所属的Runtime
+func (e *Environment) Runtime() *Runtime {
+	return e.runtime
 }
 
 func (e *Environment) Get(name string) (Object, bool) { //Get方法能够
@@ -28,3 +41,31 @@ func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
 	return val
 }
+
+// Assign 沿着作用域链更新最近的名为name的绑定，找不到时返回false
+func (e *Environment) Assign(name string, val Object) bool {
+	if _, ok := e.store[name]; ok {
+		e.store[name] = val
+		return true
+	}
+	if e.outer != nil {
+		return e.outer.Assign(name, val)
+	}
+	return false
+}
+
+// Names 返回在当前作用域中可见的所有变量名，按字母排序
+func (e *Environment) Names() []string {
+	seen := make(map[string]bool)
+	names := []string{}
+	for env := e; env != nil; env = env.outer {
+		for name := range env.store {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
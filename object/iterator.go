@@ -0,0 +1,129 @@
+package object
+
+import (
+	"math"
+	"sort"
+	"unicode/utf8"
+)
+
+// Iterable 可以用 for-in 遍历的值。新的类型只要实现Iterate，就可以像内置类型一样遍历
+type Iterable interface {
+	Object
+	Iterate() Iterator
+}
+
+// Iterator 依次产生元素，每个元素由键和值组成，遍历结束时ok为false。
+// for v in x 只使用值，for k, v in x 同时使用键和值
+type Iterator interface {
+	Next() (key, value Object, ok bool)
+}
+
+// Iterate 数组的键是下标，值是元素。遍历的是开始遍历时的元素
+func (ao *Array) Iterate() Iterator {
+	return &sliceIterator{elements: ao.Elements}
+}
+
+type sliceIterator struct {
+	elements []Object
+	i        int
+}
+
+func (it *sliceIterator) Next() (Object, Object, bool) {
+	if it.i >= len(it.elements) {
+		return nil, nil, false
+	}
+	key, value := &Integer{Value: int64(it.i)}, it.elements[it.i]
+	it.i++
+	return key, value, true
+}
+
+// Iterate 哈希表的键和值，按键排序，所以每次遍历的顺序相同
+func (h *Hash) Iterate() Iterator {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool { return lessKey(pairs[i].Key, pairs[j].Key) })
+	return &hashIterator{pairs: pairs}
+}
+
+// lessKey 哈希表的键的顺序：类型不同时按类型名排序，类型相同时按值排序
+func lessKey(a, b Object) bool {
+	if a.Type() != b.Type() {
+		return a.Type() < b.Type()
+	}
+	switch a := a.(type) {
+	case *Integer:
+		return a.Value < b.(*Integer).Value
+	case *Float:
+		return a.Value < b.(*Float).Value
+	case *String:
+		return a.Value < b.(*String).Value
+	case *Boolean:
+		return !a.Value && b.(*Boolean).Value
+	}
+	return a.Inspect() < b.Inspect()
+}
+
+type hashIterator struct {
+	pairs []HashPair
+	i     int
+}
+
+func (it *hashIterator) Next() (Object, Object, bool) {
+	if it.i >= len(it.pairs) {
+		return nil, nil, false
+	}
+	pair := it.pairs[it.i]
+	it.i++
+	return pair.Key, pair.Value, true
+}
+
+// Iterate 字符串按字符遍历，键是字符的序号，值是只有一个字符的字符串
+func (s *String) Iterate() Iterator {
+	return &stringIterator{s: s.Value}
+}
+
+type stringIterator struct {
+	s      string
+	offset int //下一个字符的字节偏移
+	i      int //下一个字符的序号
+}
+
+func (it *stringIterator) Next() (Object, Object, bool) {
+	if it.offset >= len(it.s) {
+		return nil, nil, false
+	}
+	_, size := utf8.DecodeRuneInString(it.s[it.offset:])
+	key, value := &Integer{Value: int64(it.i)}, &String{Value: it.s[it.offset : it.offset+size]}
+	it.offset += size
+	it.i++
+	return key, value, true
+}
+
+// Iterate 区间的键是序号，值是区间中的整数
+func (r *Range) Iterate() Iterator {
+	return &rangeIterator{r: r, next: r.Start}
+}
+
+type rangeIterator struct {
+	r    *Range
+	next int64
+	i    int64
+	done bool //下一个值超出了int64的范围
+}
+
+func (it *rangeIterator) Next() (Object, Object, bool) {
+	if it.done || !it.r.beforeEnd(it.next) {
+		return nil, nil, false
+	}
+	key, value := &Integer{Value: it.i}, &Integer{Value: it.next}
+
+	step := it.r.Step
+	if (step > 0 && it.next > math.MaxInt64-step) || (step < 0 && it.next < math.MinInt64-step) {
+		it.done = true
+	}
+	it.next += step
+	it.i++
+	return key, value, true
+}
@@ -4,8 +4,14 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
-	"my.com/myfile/ast"
+	"math"
+	"sort"
+	"strconv"
 	"strings"
+
+	"my.com/myfile/ast"
+	"my.com/myfile/code"
+	"my.com/myfile/token"
 )
 
 type ObjectType string //增加了代码的可读性
@@ -15,6 +21,7 @@ const (
 	ERROR_OBJ = "ERROR"
 
 	INTEGER_OBJ = "INTEGER"
+	FLOAT_OBJ   = "FLOAT"
 	STRING_OBJ  = "STRING"
 	BOOLEAN_OBJ = "BOOLEAN"
 
@@ -26,6 +33,26 @@ const (
 	FUNCTION_OBJ = "FUNCTION"
 	ARRAY_OBJ    = "ARRAY"
 	HASH_OBJ     = "HASH"
+	RANGE_OBJ    = "RANGE"
+
+	ERROR_VALUE_OBJ = "ERROR_VALUE"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CELL_OBJ              = "CELL"
+)
+
+// 错误的种类，脚本中捕获错误后通过 e["kind"] 读取
+const (
+	ERROR_KIND          = "Error" //throw抛出的错误
+	TYPE_ERROR          = "TypeError"
+	NAME_ERROR          = "NameError"
+	ARGUMENT_ERROR      = "ArgumentError"
+	INDEX_ERROR         = "IndexError"
+	ZERO_DIVISION_ERROR = "ZeroDivisionError"
+	ARITHMETIC_ERROR    = "ArithmeticError"
+	SYNTAX_ERROR        = "SyntaxError"
+	INTERNAL_ERROR      = "InternalError"
+	LIMIT_ERROR         = "LimitError" //超过执行限制或被取消，不能被catch捕获
 )
 
 // Object 定义了Object接口，接口提供了Type方法和Inspect方法
@@ -42,6 +69,26 @@ type Integer struct {
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) } //fmt.Sprintf 函数是一种通用的函数，用于将格式化的字符串生成并返回，而不是直接打印到标准输出。
 
+// Float 浮点数的处理方法
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Inspect 输出最短的能够还原出同一个值的形式，并且总是带有小数点或指数，
+// 这样输出的结果再次作为源码时仍然是浮点数
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if math.IsInf(f.Value, 0) || math.IsNaN(f.Value) {
+		return s
+	}
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return s
+}
+
 // Boolean 这个函数非常灵活，支持多种格式化的占位符，用于将不同类型的值转化为字符串。
 // 布尔值的处理方法
 type Boolean struct {
@@ -65,57 +112,210 @@ type ReturnValue struct { //返回其值
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
-// Error 错误类型
+// Error 错误类型，求值时遇到Error会一直向外传递，直到被try捕获或者到达最外层
 type Error struct {
+	Kind    string //错误的种类，例如TYPE_ERROR
 	Message string
+	Pos     token.Position //产生错误的节点的位置
+	Stack   []Frame        //错误经过的函数调用，最内层的调用在最前面
+	Value   Object         //throw抛出的值，求值时产生的错误为nil
 }
 
-// BreakValue break的处理方法
+// Catchable 判断错误能否被catch捕获，超过执行限制时不能继续执行脚本中的代码
+func (e *Error) Catchable() bool {
+	return e.Kind != LIMIT_ERROR
+}
+
+// Frame 调用栈中的一层函数调用
+type Frame struct {
+	Function string         //函数名，匿名函数为<anonymous>
+	Pos      token.Position //调用这个函数的位置，从Go中调用时无效
+}
+
+func (f Frame) String() string {
+	if f.Pos.IsValid() {
+		return "in " + f.Function + ", called at " + f.Pos.String()
+	}
+	return "in " + f.Function
+}
+
+// maxTracebackFrames 调用栈太深时(例如无限递归)只显示最内层和最外层的一部分调用
+const maxTracebackFrames = 20
+
+// BreakValue break的处理方法，Label为空时跳出最内层的循环
 type BreakValue struct {
-	Value Object
+	Label string
 }
 
 func (bv *BreakValue) Type() ObjectType { return BREAK_VALUE_OBJ }
-func (bv *BreakValue) Inspect() string  { return bv.Value.Inspect() }
+func (bv *BreakValue) Inspect() string  { return "break" }
 
-// ContinueValue continue的处理方法
+// ContinueValue continue的处理方法，Label为空时继续最内层的循环
 type ContinueValue struct {
-	Value Object
+	Label string
 }
 
 func (cv *ContinueValue) Type() ObjectType { return CONTINUE_VALUE_OBJ }
-func (cv *ContinueValue) Inspect() string  { return cv.Value.Inspect() }
+func (cv *ContinueValue) Inspect() string  { return "continue" }
 
 // Type 错误类型的处理方法
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return "ERROR: " + e.Pos.String() + ": " + e.Message
+	}
+	return "ERROR: " + e.Message
+}
+
+// Traceback 返回多行的调用栈，最内层的调用在最前面，没有经过函数调用时返回空字符串
+func (e *Error) Traceback() string {
+	if len(e.Stack) == 0 {
+		return ""
+	}
+
+	var out bytes.Buffer
+	out.WriteString("traceback (most recent call first):\n")
+	for i, frame := range e.Stack {
+		if len(e.Stack) > maxTracebackFrames && i == maxTracebackFrames/2 {
+			fmt.Fprintf(&out, "  ... %d more calls ...\n", len(e.Stack)-maxTracebackFrames)
+		}
+		if len(e.Stack) > maxTracebackFrames && i >= maxTracebackFrames/2 && i < len(e.Stack)-maxTracebackFrames/2 {
+			continue
+		}
+		out.WriteString("  " + frame.String() + "\n")
+	}
+	return out.String()
+}
+
+// ErrorValue catch捕获的错误，与Error不同，它是普通的值，不会中断求值。
+// 可以通过下标读取 "message"、"kind"、"stack" 和 "value"，throw一个ErrorValue会重新抛出原来的错误
+type ErrorValue struct {
+	Err *Error
+}
+
+func (ev *ErrorValue) Type() ObjectType { return ERROR_VALUE_OBJ }
+func (ev *ErrorValue) Inspect() string  { return ev.Err.Kind + ": " + ev.Err.Message }
+
+// Field 返回名为name的属性，不存在时返回false
+func (ev *ErrorValue) Field(name string) (Object, bool) {
+	switch name {
+	case "message":
+		return &String{Value: ev.Err.Message}, true
+	case "kind":
+		return &String{Value: ev.Err.Kind}, true
+	case "stack":
+		frames := make([]Object, len(ev.Err.Stack))
+		for i, frame := range ev.Err.Stack {
+			frames[i] = &String{Value: frame.String()}
+		}
+		return &Array{Elements: frames}, true
+	case "value": //求值时产生的错误没有抛出的值，返回nil
+		return ev.Err.Value, true
+	}
+	return nil, false
+}
 
 // Function 函数的处理方法
 type Function struct {
+	Name       string //匿名函数为空
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
-func (f *Function) Inspect() string {
-	var out bytes.Buffer
 
+// Inspect 只输出函数名和参数，例如 fn add(a, b)
+func (f *Function) Inspect() string {
 	params := []string{}
 	for _, p := range f.Parameters {
 		params = append(params, p.String())
 	}
+	return inspectFunction(f.Name, params)
+}
+
+// DisplayName 返回用于错误信息和调用栈的函数名
+func (f *Function) DisplayName() string {
+	return displayName(f.Name)
+}
+
+func displayName(name string) string {
+	if name == "" {
+		return "<anonymous>"
+	}
+	return name
+}
+
+func inspectFunction(name string, params []string) string {
+	var out bytes.Buffer
 
 	out.WriteString("fn")
+	if name != "" {
+		out.WriteString(" " + name)
+	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
-	out.WriteString(") {\n")
-	out.WriteString(f.Body.String())
-	out.WriteString("\n}")
+	out.WriteString(")")
 
 	return out.String()
 }
 
+// CompiledFunction 编译成字节码的函数，由vm执行
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int //局部变量的个数，包括参数
+	NumParameters int
+	Name          string   //匿名函数为空
+	Parameters    []string //参数名，用于Inspect
+	LocalNames    []string //局部变量名，用于错误信息
+	FreeNames     []string //引用的外层变量名，用于错误信息
+	Constants     []Object //同一次编译得到的函数共享一个常量池
+	Positions     []SourcePos
+}
+
+// SourcePos 从Offset开始的指令是由位于Pos的节点生成的
+type SourcePos struct {
+	Offset int
+	Pos    token.Position
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%s]", inspectFunction(cf.Name, cf.Parameters))
+}
+
+// PosAt 返回偏移量为offset的指令在源码中的位置
+func (cf *CompiledFunction) PosAt(offset int) token.Position {
+	i := sort.Search(len(cf.Positions), func(i int) bool { return cf.Positions[i].Offset > offset })
+	if i == 0 {
+		return token.Position{}
+	}
+	return cf.Positions[i-1].Pos
+}
+
+// Closure vm中的函数值，与Function一样类型为FUNCTION，脚本中无法区分两者
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []*Cell //引用的外层变量
+}
+
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string  { return inspectFunction(c.Fn.Name, c.Fn.Parameters) }
+
+// DisplayName 返回用于错误信息和调用栈的函数名
+func (c *Closure) DisplayName() string {
+	return displayName(c.Fn.Name)
+}
+
+// Cell 保存被闭包引用的变量，闭包和定义变量的函数通过同一个Cell读写变量。
+// Value为nil表示变量还没有定义
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string  { return "cell" }
+
 // String 字符串的处理方法
 type String struct {
 	Value string
@@ -124,8 +324,8 @@ type String struct {
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) Inspect() string  { return s.Value }
 
-// BuiltinFunction 接收任意数量的参数
-type BuiltinFunction func(args ...Object) Object
+// BuiltinFunction 接收任意数量的参数，rt是调用时的运行环境，输入输出都要通过它进行
+type BuiltinFunction func(rt *Runtime, args ...Object) Object
 
 type Builtin struct {
 	Fn BuiltinFunction
@@ -139,12 +339,20 @@ type Array struct {
 }
 
 func (ao *Array) Type() ObjectType { return ARRAY_OBJ }
-func (ao *Array) Inspect() string {
+func (ao *Array) Inspect() string  { return ao.inspect(map[Object]bool{}) }
+
+func (ao *Array) inspect(visiting map[Object]bool) string {
+	if visiting[ao] {
+		return "[...]"
+	}
+	visiting[ao] = true
+	defer delete(visiting, ao)
+
 	var out bytes.Buffer
 
 	elements := []string{}
 	for _, e := range ao.Elements {
-		elements = append(elements, e.Inspect())
+		elements = append(elements, inspect(e, visiting))
 	}
 
 	out.WriteString("[")
@@ -154,6 +362,18 @@ func (ao *Array) Inspect() string {
 	return out.String()
 }
 
+// inspect 输出容器里的元素，visiting记录正在输出的数组和哈希表，
+// 容器包含自身时输出[...]或{...}，不会无限递归
+func inspect(obj Object, visiting map[Object]bool) string {
+	switch obj := obj.(type) {
+	case *Array:
+		return obj.inspect(visiting)
+	case *Hash:
+		return obj.inspect(visiting)
+	}
+	return obj.Inspect()
+}
+
 // 哈希表实现
 type HashKey struct {
 	Type  ObjectType
@@ -170,13 +390,21 @@ type Hash struct {
 }
 
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
-func (h *Hash) Inspect() string {
+func (h *Hash) Inspect() string  { return h.inspect(map[Object]bool{}) }
+
+func (h *Hash) inspect(visiting map[Object]bool) string {
+	if visiting[h] {
+		return "{...}"
+	}
+	visiting[h] = true
+	defer delete(visiting, h)
+
 	var out bytes.Buffer
 
 	pairs := []string{}
 	for _, pair := range h.Pairs {
 		pairs = append(pairs, fmt.Sprintf("%s: %s",
-			pair.Key.Inspect(), pair.Value.Inspect()))
+			pair.Key.Inspect(), inspect(pair.Value, visiting)))
 	}
 
 	out.WriteString("{")
@@ -186,6 +414,42 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
+// Range 整数区间 Start..End 或 Start..=End，从Start开始每次增加Step，直到越过End。
+// 区间不保存元素，遍历时才逐个产生。Step不能为0，为负数时从大到小遍历
+type Range struct {
+	Start     int64
+	End       int64
+	Step      int64
+	Inclusive bool //是否包括End
+}
+
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+func (r *Range) Inspect() string {
+	op := ".."
+	if r.Inclusive {
+		op = "..="
+	}
+	s := fmt.Sprintf("%d%s%d", r.Start, op, r.End)
+	if r.Step != 1 {
+		return fmt.Sprintf("step(%s, %d)", s, r.Step)
+	}
+	return s
+}
+
+// beforeEnd v是否还没有越过区间的终点
+func (r *Range) beforeEnd(v int64) bool {
+	switch {
+	case r.Step > 0 && r.Inclusive:
+		return v <= r.End
+	case r.Step > 0:
+		return v < r.End
+	case r.Inclusive:
+		return v >= r.End
+	default:
+		return v > r.End
+	}
+}
+
 // hashable 接口实现
 type Hashable interface {
 	HashKey() HashKey
@@ -195,6 +459,14 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// HashKey 值为整数的浮点数与对应的整数相等(1.0 == 1)，所以作为键时也使用整数的HashKey
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) && f.Value >= math.MinInt64 && f.Value < -math.MinInt64 {
+		return HashKey{Type: INTEGER_OBJ, Value: uint64(int64(f.Value))}
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 func (b *Boolean) HashKey() HashKey {
 	var value uint64
 
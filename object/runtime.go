@@ -0,0 +1,109 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// DefaultMaxDepth Limits.MaxDepth为零时使用的函数调用深度限制，避免递归过深时Go的栈溢出
+const DefaultMaxDepth = 10000
+
+// checkInterval 每求值这么多个节点检查一次Context和超时，避免每一步都读取时间
+const checkInterval = 1024
+
+// 超过执行限制时产生的错误
+var (
+	ErrCancelled = errors.New("execution cancelled")
+	ErrTimeout   = errors.New("execution timed out")
+	ErrStepLimit = errors.New("step limit exceeded")
+	ErrMaxDepth  = errors.New("maximum recursion depth exceeded")
+)
+
+// Limits 限制一次求值可以使用的资源，字段为零时表示不限制
+type Limits struct {
+	MaxSteps int64         //最多求值的语法树节点数
+	MaxDepth int           //函数调用的最大深度，为零时使用DefaultMaxDepth
+	Timeout  time.Duration //一次求值最长的运行时间
+}
+
+// Runtime 求值时使用的运行环境，内置函数通过它读写输入输出，
+// 嵌入解释器的程序和测试可以替换这些流来捕获或重定向脚本的所有输入输出。
+// Context被取消或者超过Limits时，求值停止并返回错误
+type Runtime struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	Context context.Context //为nil时不检查
+	Limits  Limits
+
+	active   int //正在进行的求值的层数，最外层的求值开始时重置计数
+	steps    int64
+	depth    int
+	deadline time.Time
+}
+
+// NewRuntime 返回使用进程标准输入输出的Runtime
+func NewRuntime() *Runtime {
+	return &Runtime{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr}
+}
+
+// Begin 开始一次求值，返回的函数在求值结束时调用。
+// 最外层的求值会重置步数和调用深度并开始计时，嵌套的求值(例如内置函数中调用Wizard函数)共享这些计数
+func (rt *Runtime) Begin() (end func()) {
+	if rt.active == 0 {
+		rt.steps, rt.depth = 0, 0
+		rt.deadline = time.Time{}
+		if rt.Limits.Timeout > 0 {
+			rt.deadline = time.Now().Add(rt.Limits.Timeout)
+		}
+	}
+	rt.active++
+	return func() { rt.active-- }
+}
+
+// Step 记录求值了一个节点，超过步数限制、超时或者Context被取消时返回错误
+func (rt *Runtime) Step() error {
+	rt.steps++
+	if rt.Limits.MaxSteps > 0 && rt.steps > rt.Limits.MaxSteps {
+		return ErrStepLimit
+	}
+	if rt.steps%checkInterval != 1 {
+		return nil
+	}
+	if rt.Context != nil && rt.Context.Err() != nil {
+		if errors.Is(rt.Context.Err(), context.DeadlineExceeded) { //Context的截止时间到了也是超时
+			return ErrTimeout
+		}
+		return ErrCancelled
+	}
+	if !rt.deadline.IsZero() && time.Now().After(rt.deadline) {
+		return ErrTimeout
+	}
+	return nil
+}
+
+// Steps 返回当前这次求值已经求值的节点数
+func (rt *Runtime) Steps() int64 {
+	return rt.steps
+}
+
+// EnterCall 进入一次函数调用，超过最大深度时返回错误。成功时必须调用ExitCall
+func (rt *Runtime) EnterCall() error {
+	maxDepth := rt.Limits.MaxDepth
+	if maxDepth == 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	if rt.depth >= maxDepth {
+		return ErrMaxDepth
+	}
+	rt.depth++
+	return nil
+}
+
+func (rt *Runtime) ExitCall() {
+	rt.depth--
+}
@@ -13,11 +13,16 @@ import (
 const (
 	_           int = iota //_ int = iota 表示从0开始自增
 	LOWEST                 //
+	ASSIGN                 // = += -= *= /=
+	LOGICAL_OR             // || or
+	LOGICAL_AND            // && and
 	EQUALS                 // ==
 	LESSGREATER            // > or < or <= or >=
-	SUM                    // +
-	PRODUCT                // *
-	PREFIX                 // -X or !X
+	RANGE                  // 0..10
+	SUM                    // + - | ^
+	PRODUCT                // * / % & << >>
+	PREFIX                 // -X or !X or ~X
+	POWER                  // **，右结合，-2 ** 2 等于 -(2 ** 2)
 	CALL                   // myFunction(X)
 	INDEX
 )
@@ -34,8 +39,26 @@ var precedences = map[token.TokenType]int{
 	token.MINUS:    SUM,
 	token.SLASH:    PRODUCT,
 	token.ASTERISK: PRODUCT,
+	token.PERCENT:  PRODUCT,
+	token.BIT_AND:  PRODUCT,
+	token.SHL:      PRODUCT,
+	token.SHR:      PRODUCT,
+	token.BIT_OR:   SUM,
+	token.BIT_XOR:  SUM,
+	token.POWER:    POWER,
 	token.LPAREN:   CALL,
 	token.LBRACKET: INDEX,
+
+	token.DOTDOT:    RANGE,
+	token.DOTDOT_EQ: RANGE,
+
+	token.OR:              LOGICAL_OR,
+	token.AND:             LOGICAL_AND,
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
 }
 
 type (
@@ -44,27 +67,35 @@ type (
 ) //其中ast.Expression是一个接口
 
 type Parser struct { //parser结构体
-	l      *lexer.Lexer //指向Lexer结构体的指针
-	errors []string     //储存解析过程中的错误信息
+	l      *lexer.Lexer   //指向Lexer结构体的指针
+	errors []*lexer.Error //储存解析过程中的错误信息
+
+	panicking bool //当前语句已经出现语法错误，在同步到下一个语句之前不再记录错误
+	depth     int  //curToken之前还没有闭合的 ( [ { 的数量
 
 	curToken  token.Token //当前的token
 	peekToken token.Token //预览token来进一步判断
 
 	prefixParseFns map[token.TokenType]prefixParseFn //储存前缀表达式相关的解析函数
 	infixParseFns  map[token.TokenType]infixParseFn  //...后缀...
+
+	loops []string        //正在解析的循环的标签，由外到内，没有标签的循环为空字符串
+	label *ast.Identifier //下一个循环的标签
 }
 
 func New(l *lexer.Lexer) *Parser { //返回一个parser结构体
 	p := &Parser{ //定义p为一个Parser结构体
 		l:      l,
-		errors: []string{},
+		errors: []*lexer.Error{},
 	}
 
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn) //make()函数被用来创建一个空的映射，其中键的类型是token.TokenType，值的类型是prefixParseFn
 	p.registerPrefix(token.ID, p.parseIdentifier)              //定义了对于不同类型的Token需要使用怎样的解析函数，ID的解析函数
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
+	p.registerPrefix(token.BIT_NOT, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
@@ -73,19 +104,35 @@ func New(l *lexer.Lexer) *Parser { //返回一个parser结构体
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.WHILE, p.parseWhileExpression)
 	p.registerPrefix(token.FOR, p.parserForExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.POWER, p.parseInfixExpression)
+	p.registerInfix(token.BIT_AND, p.parseInfixExpression)
+	p.registerInfix(token.BIT_OR, p.parseInfixExpression)
+	p.registerInfix(token.BIT_XOR, p.parseInfixExpression)
+	p.registerInfix(token.SHL, p.parseInfixExpression)
+	p.registerInfix(token.SHR, p.parseInfixExpression)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
 	p.registerInfix(token.LE, p.parseInfixExpression)
 	p.registerInfix(token.GE, p.parseInfixExpression)
-	//p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.DOTDOT, p.parseInfixExpression)
+	p.registerInfix(token.DOTDOT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseLogicalExpression)
+	p.registerInfix(token.OR, p.parseLogicalExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
 
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
@@ -100,6 +147,15 @@ func New(l *lexer.Lexer) *Parser { //返回一个parser结构体
 }
 
 func (p *Parser) nextToken() {
+	switch p.curToken.Type {
+	case token.LPAREN, token.LBRACKET, token.LBRACE:
+		p.depth++
+	case token.RPAREN, token.RBRACKET, token.RBRACE:
+		if p.depth > 0 { //多余的右括号不影响后面的语句
+			p.depth--
+		}
+	}
+
 	p.curToken = p.peekToken      //让curToken往前移
 	p.peekToken = p.l.NextToken() //调用了lexer的NextToken方法，不准确地说，就是通过p访问l，再通过l的NextToken方法创建token，使用这种方法tokens不会保留
 }
@@ -123,19 +179,136 @@ func (p *Parser) expectPeek(t token.TokenType) bool { //匹配token类型
 }
 
 // 下面定义了几种类型的错误
+
+// Errors 返回所有错误信息，每条信息以 file:line:col 开头
 func (p *Parser) Errors() []string {
+	msgs := make([]string, len(p.errors))
+	for i, err := range p.errors {
+		msgs[i] = err.Error()
+	}
+	return msgs
+}
+
+// Diagnostics 与Errors相同，但是每个错误带有出错的那一行源码，可以用Excerpt显示出错的位置
+func (p *Parser) Diagnostics() []*lexer.Error {
 	return p.errors
 }
 
+// errorf 记录一条错误信息，同一个语句中第一个语法错误之后的错误不再记录
+func (p *Parser) errorf(pos token.Position, format string, a ...interface{}) {
+	p.errorAt(pos, pos, fmt.Sprintf(format, a...))
+}
+
+func (p *Parser) errorAt(pos, end token.Position, msg string) {
+	if p.panicking {
+		return
+	}
+	p.errors = append(p.errors, &lexer.Error{Pos: pos, End: end, Msg: msg, Source: p.l.SourceLine(pos)})
+}
+
+// syntaxError 记录tok处的语法错误，之后的错误很可能是这个错误引起的，
+// 所以在同步到下一个语句之前不再记录
+func (p *Parser) syntaxError(tok token.Token, format string, a ...interface{}) {
+	p.errorAt(tok.Pos, tok.End, fmt.Sprintf(format, a...))
+	p.panicking = true
+}
+
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
-		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.syntaxError(p.peekToken, "expected %s, found %s", describeType(t), describe(p.peekToken))
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	if t == token.ILLEGAL {
+		p.syntaxError(p.curToken, "unexpected character %q", p.curToken.Literal)
+		return
+	}
+	p.syntaxError(p.curToken, "expected expression, found %s", describe(p.curToken))
+}
+
+// describeType 返回错误信息中对token类型的描述
+func describeType(t token.TokenType) string {
+	switch t {
+	case token.ID:
+		return "identifier"
+	case token.INT:
+		return "integer"
+	case token.FLOAT:
+		return "float"
+	case token.STRING:
+		return "string"
+	case token.EOF:
+		return "end of input"
+	}
+	return strconv.Quote(string(t))
+}
+
+// describe 返回错误信息中对实际读到的token的描述
+func describe(tok token.Token) string {
+	switch tok.Type {
+	case token.EOF:
+		return "end of input"
+	case token.STRING:
+		return "string " + strconv.Quote(tok.Literal)
+	}
+	return strconv.Quote(tok.Literal)
+}
+
+// statementStart 可以开始一个语句的关键字，出错后在这些关键字之前恢复解析
+var statementStart = map[token.TokenType]bool{
+	token.LET:      true,
+	token.RETURN:   true,
+	token.THROW:    true,
+	token.FUNCTION: true,
+	token.BREAK:    true,
+	token.CONTINUE: true,
+	token.IF:       true,
+	token.WHILE:    true,
+	token.FOR:      true,
+	token.TRY:      true,
+}
+
+// synchronize 在语法错误之后跳过当前语句剩下的token。start是语句的第一个token，depth是语句开始时未闭合的括号数量，
+// 回到这一层之后遇到分号、换行、闭合当前块的 } 或者语句开头的关键字时停止。
+// 括号没有闭合时，新的一行中缩进不超过start的关键字也看作下一个语句的开始。
+// 出错的语句中的 { 连同与它匹配的 } 之间的内容整个跳过。
+// 停止时curToken是出错的语句的最后一个token；如果出错的语句已经读到了当前块的 }，curToken就是这个 }
+func (p *Parser) synchronize(start token.Token, depth int) {
+	p.panicking = false
+
+	for !p.curTokenIs(token.EOF) && p.depth >= depth {
+		if statementStart[p.peekToken.Type] && p.peekToken.Pos.Line > p.curToken.End.Line &&
+			p.peekToken.Pos.Column <= start.Pos.Column {
+			p.depth = depth //忽略没有闭合的括号
+			return
+		}
+		if p.depth == depth {
+			if depth > 0 && p.curTokenIs(token.RBRACE) {
+				return
+			}
+			if p.curTokenIs(token.LBRACE) { //块里面的内容属于出错的语句，整个跳过
+				p.skipBlock()
+			}
+			if p.curTokenIs(token.SEMICOLON) || p.peekTokenIs(token.EOF) {
+				return
+			}
+			if depth > 0 && p.peekTokenIs(token.RBRACE) {
+				return
+			}
+			if statementStart[p.peekToken.Type] || p.peekToken.Pos.Line > p.curToken.End.Line {
+				return
+			}
+		}
+		p.nextToken()
+	}
+}
+
+// skipBlock 从curToken的 { 跳到与它匹配的 } ，没有匹配的 } 时停在EOF
+func (p *Parser) skipBlock() {
+	depth := p.depth
+	p.nextToken()
+	for !p.curTokenIs(token.EOF) && !(p.curTokenIs(token.RBRACE) && p.depth == depth+1) {
+		p.nextToken()
+	}
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -143,13 +316,24 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program.Statements = []ast.Statement{} //将数组Statement作为ast.Statement数组
 
 	for !p.curTokenIs(token.EOF) { //直到p的curtoken是EOF类型，执行for循环，这个循环的目的是生成所有的token，同时生成语法树
+		start, depth := p.curToken, p.depth
 		stmt := p.parseStatement()
 		if stmt != nil { //在循环的1，2次，p.curtoken为空，所以stmt没有被分配
 			program.Statements = append(program.Statements, stmt)
 		}
+		if p.panicking {
+			p.synchronize(start, depth)
+		}
 		p.nextToken()
 	}
 
+	for _, tok := range p.l.Comments() {
+		program.Comments = append(program.Comments, &ast.Comment{Token: tok})
+	}
+	if lexErrors := p.l.Diagnostics(); len(lexErrors) > 0 { //词法错误排在前面
+		p.errors = append(append([]*lexer.Error{}, lexErrors...), p.errors...)
+	}
+
 	return program
 }
 
@@ -159,10 +343,22 @@ func (p *Parser) parseStatement() ast.Statement { //判断应该返回什么类
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
+	case token.FUNCTION:
+		if p.peekTokenIs(token.ID) { //fn name() {} 是函数声明，fn() {} 是函数表达式
+			return p.parseFunctionStatement()
+		}
+		return p.parseExpressionStatement()
 	case token.BREAK:
 		return p.parseBreakStatement()
 	case token.CONTINUE:
 		return p.parseContinueStatement()
+	case token.ID:
+		if p.peekTokenIs(token.COLON) {
+			return p.parseLabeledStatement()
+		}
+		return p.parseExpressionStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -184,6 +380,9 @@ func (p *Parser) parseLetStatement() *ast.LetStatement { //Let语句的抽象语
 	p.nextToken()
 
 	stmt.Value = p.parseExpression(LOWEST) //ID的值
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok && fl.Name == "" {
+		fl.Name = stmt.Name.Value //用变量名作为函数名，用于错误信息和调用栈
+	}
 
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
@@ -205,11 +404,26 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement { //返回语句的
 
 	return stmt
 }
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement { //抛出错误
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseBreakStatement() *ast.BreakStatement { //跳出语句
 	stmt := &ast.BreakStatement{Token: p.curToken}
+	stmt.Label = p.parseLoopLabel()
 
-	if !p.expectPeek(token.SEMICOLON) {
-		return nil
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
 	}
 
 	return stmt
@@ -217,14 +431,73 @@ func (p *Parser) parseBreakStatement() *ast.BreakStatement { //跳出语句
 
 func (p *Parser) parseContinueStatement() *ast.ContinueStatement { //继续语句
 	stmt := &ast.ContinueStatement{Token: p.curToken}
+	stmt.Label = p.parseLoopLabel()
 
-	if !p.expectPeek(token.SEMICOLON) {
-		return nil
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
 	}
 
 	return stmt
 }
 
+// parseLoopLabel 解析break和continue后面可选的标签，标签必须与关键字在同一行。
+// 同时检查它们是否在对应的循环中
+func (p *Parser) parseLoopLabel() *ast.Identifier {
+	keyword := p.curToken
+
+	var label *ast.Identifier
+	if p.peekTokenIs(token.ID) && p.peekToken.Pos.Line == keyword.Pos.Line { //下一行的标识符属于新的语句
+		p.nextToken()
+		label = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	if len(p.loops) == 0 {
+		p.errorf(keyword.Pos, "%s outside loop", keyword.Literal)
+		return label
+	}
+	if label != nil && !p.inLoop(label.Value) {
+		p.errorf(label.Pos(), "%s to undefined label %s", keyword.Literal, label.Value)
+	}
+	return label
+}
+
+func (p *Parser) inLoop(label string) bool {
+	for _, l := range p.loops {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLabeledStatement 解析 outer: while (...) {...} 这样带标签的循环
+func (p *Parser) parseLabeledStatement() ast.Statement {
+	label := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	p.nextToken() //跳过标签
+
+	if !p.peekTokenIs(token.WHILE) && !p.peekTokenIs(token.FOR) {
+		p.errorf(label.Pos(), "label %s must be followed by a loop", label.Value)
+		return nil
+	}
+	p.nextToken()
+
+	p.label = label
+	return p.parseExpressionStatement()
+}
+
+// enterLoop 在解析循环体之前调用，返回的函数在循环体解析完成后调用
+func (p *Parser) enterLoop() (*ast.Identifier, func()) {
+	label := p.label
+	p.label = nil
+
+	name := ""
+	if label != nil {
+		name = label.Value
+	}
+	p.loops = append(p.loops, name)
+	return label, func() { p.loops = p.loops[:len(p.loops)-1] }
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement { //创建表达式结构体
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 
@@ -284,8 +557,21 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorf(p.curToken.Pos, "could not parse %q as integer", p.curToken.Literal)
+		return nil
+	}
+
+	lit.Value = value
+
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.errorf(p.curToken.Pos, "could not parse %q as float", p.curToken.Literal)
 		return nil
 	}
 
@@ -315,12 +601,43 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression { //
 	}
 
 	precedence := p.curPrecedence() //返回当前Token的优先级给parseExpression判断
+	if p.curTokenIs(token.POWER) {
+		precedence-- //右结合，2 ** 3 ** 2 等于 2 ** (3 ** 2)
+	}
 	p.nextToken()
 	expression.Right = p.parseExpression(precedence)
 
 	return expression
 }
 
+func (p *Parser) parseLogicalExpression(left ast.Expression) ast.Expression { //处理 && 和 ||
+	expression := p.parseInfixExpression(left).(*ast.InfixExpression)
+	expression.Operator = string(expression.Token.Type) //and和or统一为&&和||
+	return expression
+}
+
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression { //处理赋值，赋值是右结合的
+	expression := &ast.AssignExpression{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+		Target:   target,
+	}
+
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression: //变量或者数组、哈希表的元素
+	default:
+		if target != nil {
+			p.errorf(p.curToken.Pos, "cannot assign to %s", target.String())
+		}
+		return nil
+	}
+
+	p.nextToken()
+	expression.Value = p.parseExpression(ASSIGN - 1) //使用更低的优先级，使 a = b = 1 解析为 a = (b = 1)
+
+	return expression
+}
+
 func (p *Parser) parseBoolean() ast.Expression { //处理布尔值
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
 }
@@ -337,6 +654,50 @@ func (p *Parser) parseGroupedExpression() ast.Expression { //处理表达式有
 	return exp
 }
 
+func (p *Parser) parseTryExpression() ast.Expression { //处理try语句
+	expression := &ast.TryExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	expression.Body = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LPAREN) {
+			return nil
+		}
+		if !p.expectPeek(token.ID) {
+			return nil
+		}
+		expression.Param = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		if !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		expression.Finally = p.parseBlockStatement()
+	}
+
+	if expression.Catch == nil && expression.Finally == nil {
+		p.errorf(expression.Token.Pos, "try must be followed by catch or finally")
+		return nil
+	}
+
+	return expression
+}
+
 func (p *Parser) parseIfExpression() ast.Expression { //处理if语句
 	expression := &ast.IfExpression{Token: p.curToken}
 
@@ -375,34 +736,74 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement { //块的解析函
 	block.Statements = []ast.Statement{}
 
 	p.nextToken()
+	depth := p.depth
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+		start := p.curToken
 		stmt := p.parseStatement()
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
+		if p.panicking {
+			p.synchronize(start, depth)
+			if p.curTokenIs(token.RBRACE) && p.depth <= depth { //出错的语句已经读到了块结尾的 }
+				break
+			}
+		}
 		p.nextToken()
 	}
 
+	if p.curTokenIs(token.EOF) { //块没有闭合，错误指向块开头的 {
+		p.syntaxError(block.Token, "expected %s, found %s", describeType(token.RBRACE), describe(p.curToken))
+	}
+
 	return block
 }
 
 func (p *Parser) parseFunctionLiteral() ast.Expression { //处理函数的定义
 	lit := &ast.FunctionLiteral{Token: p.curToken}
 
-	if !p.expectPeek(token.LPAREN) {
+	if !p.parseFunction(lit) {
 		return nil
 	}
+	return lit
+}
+
+func (p *Parser) parseFunctionStatement() *ast.FunctionStatement { //处理函数声明
+	stmt := &ast.FunctionStatement{Token: p.curToken}
+	p.nextToken()
+
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	stmt.Function = &ast.FunctionLiteral{Token: stmt.Token, Name: stmt.Name.Value}
+	if !p.parseFunction(stmt.Function) {
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// parseFunction 解析函数的参数列表和函数体，当前token是左括号之前的 fn 或者函数名
+func (p *Parser) parseFunction(lit *ast.FunctionLiteral) bool {
+	if !p.expectPeek(token.LPAREN) {
+		return false
+	}
 
 	lit.Parameters = p.parseFunctionParameters()
 
 	if !p.expectPeek(token.LBRACE) {
-		return nil
+		return false
 	}
 
+	loops := p.loops
+	p.loops = nil //break和continue不能跳出函数
 	lit.Body = p.parseBlockStatement()
+	p.loops = loops
 
-	return lit
+	return true
 }
 
 func (p *Parser) parseFunctionParameters() []*ast.Identifier { //处理函数的参数
@@ -470,8 +871,15 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) { //
 	p.infixParseFns[tokenType] = fn
 }
 func (p *Parser) parserForExpression() ast.Expression { //处理for循环
+	if p.peekTokenIs(token.ID) { //for x in ...
+		return p.parseForInExpression()
+	}
 	exp := &ast.ForExpression{Token: p.curToken} //for
 
+	var leaveLoop func()
+	exp.Label, leaveLoop = p.enterLoop()
+	defer leaveLoop()
+
 	if !p.peekTokenIs(token.COLON) { //匹配冒号
 		//如果匹配失败，则初始化
 		p.nextToken() //跳过for
@@ -491,7 +899,7 @@ func (p *Parser) parserForExpression() ast.Expression { //处理for循环
 		return nil
 	}
 	p.nextToken()
-	exp.Cycleop = p.parseLetStatement()
+	exp.Cycleop = p.parseStatement() //可以是let语句，也可以是赋值表达式
 	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
@@ -500,9 +908,48 @@ func (p *Parser) parserForExpression() ast.Expression { //处理for循环
 
 	return exp
 }
+
+// parseForInExpression 解析 for v in x { } 和 for k, v in x { }
+func (p *Parser) parseForInExpression() ast.Expression {
+	exp := &ast.ForInExpression{Token: p.curToken}
+
+	var leaveLoop func()
+	exp.Label, leaveLoop = p.enterLoop()
+	defer leaveLoop()
+
+	p.nextToken()
+	exp.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.expectPeek(token.ID) {
+			return nil
+		}
+		exp.Key = exp.Value
+		exp.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+	exp.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	exp.Body = p.parseBlockStatement()
+
+	return exp
+}
+
 func (p *Parser) parseWhileExpression() ast.Expression { //处理While循环
 	exp := &ast.WhileExpression{Token: p.curToken}
 
+	var leaveLoop func()
+	exp.Label, leaveLoop = p.enterLoop()
+	defer leaveLoop()
+
 	if !p.expectPeek(token.LPAREN) { //匹配括号
 		return nil
 	}
@@ -552,17 +999,32 @@ func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	return list
 }
 
+// parseIndexExpression 解析 left[index]，以及切片 left[low:high]
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
-	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
+	tok := p.curToken
 
-	p.nextToken()
-	exp.Index = p.parseExpression(LOWEST)
+	var index ast.Expression
+	if !p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		index = p.parseExpression(LOWEST)
+	}
+	if !p.peekTokenIs(token.COLON) {
+		if !p.expectPeek(token.RBRACKET) {
+			return nil
+		}
+		return &ast.IndexExpression{Token: tok, Left: left, Index: index}
+	}
 
+	slice := &ast.SliceExpression{Token: tok, Left: left, Low: index}
+	p.nextToken() //跳过':'
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		slice.High = p.parseExpression(LOWEST)
+	}
 	if !p.expectPeek(token.RBRACKET) {
 		return nil
 	}
-
-	return exp
+	return slice
 }
 
 func (p *Parser) parseHashLiteral() ast.Expression {
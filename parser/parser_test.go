@@ -0,0 +1,297 @@
+package parser
+
+import (
+	"testing"
+
+	"my.com/myfile/ast"
+	"my.com/myfile/lexer"
+)
+
+func TestLoopControlErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"break;", "1:1: break outside loop"},
+		{"if (true) { continue }", "1:13: continue outside loop"},
+		{"while (true) { fn() { break; } }", "1:23: break outside loop"},
+		{"while (true) { break outer; }", "1:22: break to undefined label outer"},
+		{"outer: let x = 1;", "1:1: label outer must be followed by a loop"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("%q: expected error %q, got none", tt.input, tt.expectedError)
+			continue
+		}
+		if errors[0] != tt.expectedError {
+			t.Errorf("%q: wrong error. expected=%q, got=%q", tt.input, tt.expectedError, errors[0])
+		}
+	}
+}
+
+func TestLabeledLoops(t *testing.T) {
+	input := `outer: while (true) { inner: for let i = 0 : i < 1 : i += 1 { continue outer; break inner } }`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser has errors: %q", p.Errors())
+	}
+
+	expected := "outer: whiletrueinner: Forlet i = 0;(i < 1)(i += 1)continue outer;break inner;"
+	if program.String() != expected {
+		t.Errorf("program.String() wrong. expected=%q, got=%q", expected, program.String())
+	}
+}
+
+func TestForInExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"for x in arr { x }", "for x in arr x"},
+		{"for k, v in {1: 2} { k }", "for k, v in {1:2} k"},
+		{"outer: for i in 0..n - 1 { break outer }", "outer: for i in (0 .. (n - 1)) break outer;"},
+		{"for i in a..b { }", "for i in (a .. b) "},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Errorf("%q: parser has errors: %q", tt.input, p.Errors())
+			continue
+		}
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+
+	errorTests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"for x of arr { }", `1:7: expected "in", found "of"`},
+		{"for k, 1 in arr { }", `1:8: expected identifier, found "1"`},
+		{"for x in arr x", `1:14: expected "{", found "x"`},
+	}
+
+	for _, tt := range errorTests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("%q: expected error %q, got none", tt.input, tt.expectedError)
+			continue
+		}
+		if errors[0] != tt.expectedError {
+			t.Errorf("%q: wrong error. expected=%q, got=%q", tt.input, tt.expectedError, errors[0])
+		}
+	}
+}
+
+func TestRangeAndSliceExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"0..=n", "(0 ..= n)"},
+		{"a..b == c", "((a .. b) == c)"},
+		{"a[1:3]", "(a[1:3])"},
+		{"a[:n - 1]", "(a[:(n - 1)])"},
+		{"a[-2:]", "(a[(-2):])"},
+		{"a[:]", "(a[:])"},
+		{"a[1:][0]", "((a[1:])[0])"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Errorf("%q: parser has errors: %q", tt.input, p.Errors())
+			continue
+		}
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+
+	p := New(lexer.New("a[1:2:3]"))
+	p.ParseProgram()
+	if errors := p.Errors(); len(errors) == 0 || errors[0] != `1:6: expected "]", found ":"` {
+		t.Errorf("wrong errors. got=%q", errors)
+	}
+}
+
+func TestLoopLabelOnSameLine(t *testing.T) {
+	input := "let i = 0\nwhile (true) {\n\tbreak\n\ti += 1\n}\nouter: while (true) { continue outer }"
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser has errors: %q", p.Errors())
+	}
+
+	expected := "let i = 0;whiletruebreak;(i += 1)outer: whiletruecontinue outer;"
+	if program.String() != expected {
+		t.Errorf("program.String() wrong. expected=%q, got=%q", expected, program.String())
+	}
+}
+
+func TestTryExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { f() } catch (e) { g(e) }", "try f()catch(e) g(e)"},
+		{"try { f() } finally { g() }", "try f()finally g()"},
+		{"let x = try { 1 } catch (err) { 2 } finally { 3 };", "let x = try 1catch(err) 2finally 3;"},
+		{"throw \"boom\";", "throw boom;"},
+		{"throw {\"code\": 1}", "throw {code:1};"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Errorf("%q: parser has errors: %q", tt.input, p.Errors())
+			continue
+		}
+		if program.String() != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+}
+
+func TestTryExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedError string
+	}{
+		{"try { 1 }", "1:1: try must be followed by catch or finally"},
+		{"try { 1 } catch { 2 }", `1:17: expected "(", found "{"`},
+		{"try { 1 } catch (1) { 2 }", `1:18: expected identifier, found "1"`},
+		{"try 1 catch (e) { 2 }", `1:5: expected "{", found "1"`},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("%q: expected error %q, got none", tt.input, tt.expectedError)
+			continue
+		}
+		if errors[0] != tt.expectedError {
+			t.Errorf("%q: wrong error. expected=%q, got=%q", tt.input, tt.expectedError, errors[0])
+		}
+	}
+}
+
+func TestFunctionStatement(t *testing.T) {
+	p := New(lexer.New("fn add(a, b) { a + b } let sub = fn(a, b) { a - b }; fn() { 1 }"))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser has errors: %q", p.Errors())
+	}
+	if len(program.Statements) != 3 {
+		t.Fatalf("expected 3 statements, got %d", len(program.Statements))
+	}
+
+	decl, ok := program.Statements[0].(*ast.FunctionStatement)
+	if !ok {
+		t.Fatalf("statement 0 is not *ast.FunctionStatement. got=%T", program.Statements[0])
+	}
+	if decl.Name.Value != "add" || decl.Function.Name != "add" || len(decl.Function.Parameters) != 2 {
+		t.Errorf("wrong declaration. got=%s", decl.String())
+	}
+	if decl.String() != "fn add(a, b) (a + b)" {
+		t.Errorf("wrong String(). got=%q", decl.String())
+	}
+
+	let := program.Statements[1].(*ast.LetStatement)
+	if fl := let.Value.(*ast.FunctionLiteral); fl.Name != "sub" {
+		t.Errorf("let should name the function literal. got=%q", fl.Name)
+	}
+
+	expr := program.Statements[2].(*ast.ExpressionStatement)
+	if fl := expr.Expression.(*ast.FunctionLiteral); fl.Name != "" {
+		t.Errorf("anonymous function got name %q", fl.Name)
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedErrors []string
+	}{
+		{"if (x { return 1 }\nlet y = 2", []string{`1:7: expected ")", found "{"`}},
+		{"let = 1;\nlet x = ;\nputs(x)", []string{
+			`1:5: expected identifier, found "="`,
+			`2:9: expected expression, found ";"`,
+		}},
+		{"fn f(a, b { a }\nf(1, 2)", []string{`1:11: expected ")", found "{"`}},
+		{"let h = {\n  \"a\": 1\n  \"b\": 2\n}\nh", []string{`3:3: expected ",", found string "b"`}},
+		{"if (x) { foo( }\nlet y = 1\nlet z = ;", []string{
+			`1:15: expected expression, found "}"`,
+			`3:9: expected expression, found ";"`,
+		}},
+		{"let f = fn() {\n  let a = (1 + ;\n  return a\n}\nlet b = f(", []string{
+			`2:16: expected expression, found ";"`,
+			`5:11: expected expression, found end of input`,
+		}},
+		{"while (true) { let = 1; break }", []string{`1:20: expected identifier, found "="`}},
+		{"let x = 1 @ 2", []string{`1:11: unexpected character "@"`}},
+		{"if (true) { puts(1)", []string{`1:11: expected "}", found end of input`}},
+		{"let f = fn(x) { x", []string{`1:15: expected "}", found end of input`}},
+		{"while (false) {", []string{`1:15: expected "}", found end of input`}},
+		{"let f = fn() {\n  if (x) {\n    1\n  }\n", []string{`1:14: expected "}", found end of input`}},
+		{"let x = 1;\nelse {\n  puts(1)\n}", []string{`2:1: expected expression, found "else"`}},
+		{"if (x) {\n} catch (e) {\n  puts(e)\n} finally {\n}\nlet y = ;", []string{
+			`2:3: expected expression, found "catch"`,
+			`6:9: expected expression, found ";"`,
+		}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != len(tt.expectedErrors) {
+			t.Errorf("%q: expected %d errors, got %d: %q", tt.input, len(tt.expectedErrors), len(errors), errors)
+			continue
+		}
+		for i, expected := range tt.expectedErrors {
+			if errors[i] != expected {
+				t.Errorf("%q: errors[%d] wrong. expected=%q, got=%q", tt.input, i, expected, errors[i])
+			}
+		}
+	}
+}
+
+func TestErrorExcerpt(t *testing.T) {
+	p := New(lexer.NewFile("a.wz", "let x = 1;\n\tputs(x, \"a\" \"b\")\n"))
+	p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("expected 1 error, got %q", p.Errors())
+	}
+	if diagnostics[0].Error() != `a.wz:2:14: expected ")", found string "b"` {
+		t.Errorf("wrong error. got=%q", diagnostics[0].Error())
+	}
+	expected := "\tputs(x, \"a\" \"b\")\n\t            ^^^"
+	if diagnostics[0].Excerpt() != expected {
+		t.Errorf("wrong excerpt. expected=%q, got=%q", expected, diagnostics[0].Excerpt())
+	}
+}
@@ -0,0 +1,170 @@
+package repl
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"my.com/myfile/ast"
+	"my.com/myfile/evaluator"
+	"my.com/myfile/lexer"
+	"my.com/myfile/object"
+	"my.com/myfile/parser"
+	"my.com/myfile/token"
+)
+
+// command 一条以冒号开头的REPL命令
+type command struct {
+	name  string
+	args  string //参数的说明，用于 :help，放在[]中的参数可以省略
+	usage string
+	run   func(s *session, arg string)
+}
+
+var commands []command
+
+func init() { //commands中的函数会调用printHelp，所以在init中初始化以避免循环引用
+	commands = []command{
+		{"help", "", "show this help", (*session).printHelp},
+		{"env", "", "list the bindings in the environment", (*session).printEnv},
+		{"tokens", "<code>", "print the tokens produced by the lexer", (*session).printTokens},
+		{"ast", "<code>", "print the syntax tree produced by the parser", (*session).printAST},
+		{"type", "<expr>", "evaluate an expression and print its type", (*session).printType},
+		{"load", "<file>", "run a file in the current session", (*session).load},
+		{"save", "<file>", "write the accepted input of this session to a file", (*session).save},
+		{"reset", "", "clear all bindings and the session history", (*session).reset},
+		{"backend", "[eval|vm]", "show or switch how input is executed", (*session).switchBackend},
+		{"quit", "", "exit the REPL", (*session).exit},
+	}
+}
+
+func isCommand(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), ":")
+}
+
+// runCommand 执行一行REPL命令，例如 ":type 1 + 2"
+func (s *session) runCommand(line string) {
+	line = strings.TrimPrefix(strings.TrimSpace(line), ":")
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	for _, cmd := range commands {
+		if cmd.name == name {
+			if cmd.args != "" && !strings.HasPrefix(cmd.args, "[") && arg == "" {
+				fmt.Fprintf(s.out, "usage: :%s %s\n", cmd.name, cmd.args)
+				return
+			}
+			cmd.run(s, arg)
+			return
+		}
+	}
+	fmt.Fprintf(s.out, "unknown command :%s, type :help for a list of commands\n", name)
+}
+
+func (s *session) printHelp(string) {
+	for _, cmd := range commands {
+		fmt.Fprintf(s.out, "  %-16s %s\n", strings.TrimSpace(":"+cmd.name+" "+cmd.args), cmd.usage)
+	}
+}
+
+func (s *session) printEnv(string) {
+	for _, name := range s.env.Names() {
+		val, _ := s.env.Get(name)
+		fmt.Fprintf(s.out, "%s = %s\n", name, inspect(val))
+	}
+}
+
+func (s *session) printTokens(code string) {
+	l := lexer.New(code)
+	for tok := l.NextToken(); ; tok = l.NextToken() {
+		fmt.Fprintf(s.out, "%-6s %-10s %q\n", tok.Pos, tok.Type, tok.Literal)
+		if tok.Type == token.EOF {
+			break
+		}
+	}
+	for _, msg := range l.Errors() {
+		fmt.Fprintln(s.out, msg)
+	}
+}
+
+func (s *session) printAST(code string) {
+	p := parser.New(lexer.New(code))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(s.out, p.Diagnostics())
+		return
+	}
+	ast.Fprint(s.out, program)
+}
+
+func (s *session) printType(code string) {
+	p := parser.New(lexer.New(code))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		printParserErrors(s.out, p.Diagnostics())
+		return
+	}
+
+	evaluated := s.run(program)
+	if errObj, ok := evaluated.(*object.Error); ok {
+		fmt.Fprintln(s.out, errObj.Inspect())
+		return
+	}
+	if evaluated == nil {
+		evaluated = evaluator.NULL
+	}
+	fmt.Fprintln(s.out, evaluated.Type())
+}
+
+func (s *session) load(filename string) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		fmt.Fprintf(s.out, "error: %v\n", err)
+		return
+	}
+	s.eval(filename, string(src))
+}
+
+func (s *session) save(filename string) {
+	content := strings.Join(s.accepted, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		fmt.Fprintf(s.out, "error: %v\n", err)
+		return
+	}
+	fmt.Fprintf(s.out, "saved %d entries to %s\n", len(s.accepted), filename)
+}
+
+func (s *session) reset(string) {
+	s.env = object.NewEnvironmentWithRuntime(s.runtime)
+	s.accepted = nil
+}
+
+// switchBackend 切换执行方式。vm中定义的函数不能由求值器调用，所以切换时清空所有绑定
+func (s *session) switchBackend(name string) {
+	switch name {
+	case "":
+		fmt.Fprintf(s.out, "backend: %s\n", s.backend)
+	case s.backend:
+		fmt.Fprintf(s.out, "already using %s\n", name)
+	case BackendEval, BackendVM:
+		s.backend = name
+		s.reset("")
+		fmt.Fprintf(s.out, "switched to %s, bindings cleared\n", name)
+	default:
+		fmt.Fprintf(s.out, "unknown backend %s, expected %s or %s\n", name, BackendEval, BackendVM)
+	}
+}
+
+func (s *session) exit(string) {
+	s.quit = true
+}
+
+func inspect(obj object.Object) string {
+	if obj == nil {
+		return "null"
+	}
+	return obj.Inspect()
+}
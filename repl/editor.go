@@ -0,0 +1,449 @@
+package repl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// errInterrupted 用户在编辑时按下了Ctrl-C，当前输入被丢弃
+var errInterrupted = errors.New("interrupted")
+
+// lineReader 读取一行输入，prompt是读取前显示的提示符
+type lineReader interface {
+	readLine(prompt string) (string, error)
+}
+
+// scannerReader 在stdin不是终端时使用，逐行读取，不做任何编辑
+type scannerReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+func (r *scannerReader) readLine(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+	if r.scanner.Scan() {
+		return r.scanner.Text(), nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", io.EOF
+}
+
+// terminalReader 在终端上使用行编辑器，读取时把终端切换到raw模式，读完后恢复，
+// 这样求值时的输出不受raw模式影响
+type terminalReader struct {
+	fd     int
+	editor *lineEditor
+}
+
+func (r *terminalReader) readLine(prompt string) (string, error) {
+	state, err := makeRaw(r.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restore(r.fd, state)
+	return r.editor.readLine(prompt)
+}
+
+// newLineReader 输入和输出都是终端时使用行编辑器，否则使用bufio.Scanner
+func (s *session) newLineReader(in io.Reader) lineReader {
+	inFile, ok1 := in.(*os.File)
+	outFile, ok2 := s.out.(*os.File)
+	if ok1 && ok2 && isTerminal(int(inFile.Fd())) && isTerminal(int(outFile.Fd())) {
+		return &terminalReader{
+			fd: int(inFile.Fd()),
+			editor: &lineEditor{
+				in:       bufio.NewReader(inFile),
+				out:      s.out,
+				history:  loadHistory(historyPath()),
+				complete: s.complete,
+			},
+		}
+	}
+	return &scannerReader{scanner: bufio.NewScanner(in), out: s.out}
+}
+
+// 按键，控制键就是对应的ASCII码
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyCtrlG     = 7
+	keyCtrlH     = 8
+	keyTab       = 9
+	keyCtrlJ     = 10
+	keyCtrlK     = 11
+	keyCtrlL     = 12
+	keyEnter     = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlR     = 18
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEscape    = 27
+	keyBackspace = 127
+)
+
+// 由转义序列表示的按键，使用负数以免和字符冲突
+const (
+	keyUnknown rune = -(iota + 1)
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyDelete
+)
+
+// lineEditor 一个简单的行编辑器，支持光标移动、历史记录、Ctrl-R反向搜索和Tab补全。
+// 它只负责处理按键和重绘当前行，终端的raw模式由调用者设置
+type lineEditor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	history  *history
+	complete func(prefix string) []string //返回以prefix开头的候选词
+
+	prompt string
+	buf    []rune
+	pos    int //光标在buf中的位置
+}
+
+// readLine 读取一行，按Enter结束。在空行上按Ctrl-D返回io.EOF，按Ctrl-C返回errInterrupted
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	e.prompt, e.buf, e.pos = prompt, nil, 0
+	histIndex := len(e.history.entries) //正在浏览的历史记录，等于len时表示正在编辑的新行
+	var pending []rune                  //开始浏览历史记录之前输入的内容
+
+	e.refresh()
+	for {
+		key, err := e.readKey()
+		if key == keyCtrlR {
+			key, err = e.search() //搜索结束时的按键继续按普通按键处理
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch key {
+		case keyEnter, keyCtrlJ:
+			return e.accept(), nil
+		case keyCtrlC:
+			e.write("^C\r\n")
+			return "", errInterrupted
+		case keyCtrlD:
+			if len(e.buf) == 0 {
+				e.write("\r\n")
+				return "", io.EOF
+			}
+			e.deleteRune()
+		case keyBackspace, keyCtrlH:
+			if e.pos > 0 {
+				e.pos--
+				e.deleteRune()
+			}
+		case keyDelete:
+			e.deleteRune()
+		case keyLeft, keyCtrlB:
+			if e.pos > 0 {
+				e.pos--
+			}
+		case keyRight, keyCtrlF:
+			if e.pos < len(e.buf) {
+				e.pos++
+			}
+		case keyHome, keyCtrlA:
+			e.pos = 0
+		case keyEnd, keyCtrlE:
+			e.pos = len(e.buf)
+		case keyCtrlK:
+			e.buf = e.buf[:e.pos]
+		case keyCtrlU:
+			e.buf = append([]rune{}, e.buf[e.pos:]...)
+			e.pos = 0
+		case keyCtrlW:
+			e.deleteWord()
+		case keyCtrlL:
+			e.write("\x1b[H\x1b[2J")
+		case keyUp, keyCtrlP:
+			if histIndex > 0 {
+				if histIndex == len(e.history.entries) {
+					pending = e.buf
+				}
+				histIndex--
+				e.setLine(e.history.entries[histIndex])
+			}
+		case keyDown, keyCtrlN:
+			if histIndex < len(e.history.entries) {
+				histIndex++
+				if histIndex == len(e.history.entries) {
+					e.buf, e.pos = pending, len(pending)
+				} else {
+					e.setLine(e.history.entries[histIndex])
+				}
+			}
+		case keyTab:
+			e.completeWord()
+		default:
+			if key >= ' ' {
+				e.insert(key)
+			}
+		}
+		e.refresh()
+	}
+}
+
+// accept 结束当前行的编辑并把它加入历史记录
+func (e *lineEditor) accept() string {
+	e.pos = len(e.buf)
+	e.refresh()
+	e.write("\r\n")
+	line := string(e.buf)
+	e.history.add(line)
+	return line
+}
+
+// search 处理Ctrl-R反向搜索：输入的字符作为查询，再按Ctrl-R查找更早的匹配。
+// 按Ctrl-G取消搜索，恢复原来的内容；按其他键结束搜索，找到的行留在编辑区，
+// 这个按键作为返回值交给readLine处理，例如Enter直接执行找到的行
+func (e *lineEditor) search() (rune, error) {
+	entries := e.history.entries
+	original, originalPos := e.buf, e.pos
+	var query []rune
+	index := len(entries) //当前匹配的历史记录
+	failed := false
+
+	find := func(from int) {
+		for i := from; i >= 0; i-- {
+			if j := strings.Index(entries[i], string(query)); j >= 0 {
+				index = i
+				e.setLine(entries[i])
+				e.pos = utf8.RuneCountInString(entries[i][:j])
+				failed = false
+				return
+			}
+		}
+		failed = true
+	}
+
+	for {
+		label := "reverse-i-search"
+		if failed {
+			label = "failed reverse-i-search"
+		}
+		e.draw(fmt.Sprintf("(%s)`%s': ", label, string(query)), e.buf, e.pos)
+
+		key, err := e.readKey()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case key == keyCtrlG || key == keyCtrlC:
+			e.buf, e.pos = original, originalPos
+			return 0, nil
+		case key == keyCtrlR:
+			if len(query) > 0 {
+				find(index - 1)
+			}
+		case key == keyBackspace || key == keyCtrlH:
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+			}
+			if len(query) == 0 {
+				e.buf, e.pos = original, originalPos
+				index, failed = len(entries), false
+			} else {
+				find(len(entries) - 1)
+			}
+		case key >= ' ':
+			query = append(query, key)
+			find(min(index, len(entries)-1))
+		default:
+			return key, nil
+		}
+	}
+}
+
+// completeWord 补全光标前的标识符。只有一个候选词时直接补全，
+// 有多个时补全它们的公共前缀，没有公共前缀可以补全时列出所有候选词
+func (e *lineEditor) completeWord() {
+	if e.complete == nil {
+		return
+	}
+	start := e.pos
+	for start > 0 && isIdentRune(e.buf[start-1]) {
+		start--
+	}
+	prefix := string(e.buf[start:e.pos])
+	if prefix == "" {
+		return
+	}
+
+	candidates := e.complete(prefix)
+	if len(candidates) == 0 {
+		return
+	}
+	if common := commonPrefix(candidates); len(common) > len(prefix) {
+		for _, r := range common[len(prefix):] {
+			e.insert(r)
+		}
+		return
+	}
+	if len(candidates) > 1 {
+		e.write("\r\n" + strings.Join(candidates, "  ") + "\r\n")
+	}
+}
+
+func (e *lineEditor) insert(r rune) {
+	e.buf = append(e.buf, 0)
+	copy(e.buf[e.pos+1:], e.buf[e.pos:])
+	e.buf[e.pos] = r
+	e.pos++
+}
+
+// deleteRune 删除光标处的字符
+func (e *lineEditor) deleteRune() {
+	if e.pos < len(e.buf) {
+		e.buf = append(e.buf[:e.pos], e.buf[e.pos+1:]...)
+	}
+}
+
+// deleteWord 删除光标前的一个单词及其前面的空白
+func (e *lineEditor) deleteWord() {
+	start := e.pos
+	for start > 0 && e.buf[start-1] == ' ' {
+		start--
+	}
+	for start > 0 && e.buf[start-1] != ' ' {
+		start--
+	}
+	e.buf = append(e.buf[:start], e.buf[e.pos:]...)
+	e.pos = start
+}
+
+func (e *lineEditor) setLine(line string) {
+	e.buf = []rune(line)
+	e.pos = len(e.buf)
+}
+
+func (e *lineEditor) refresh() {
+	e.draw(e.prompt, e.buf, e.pos)
+}
+
+// draw 重绘当前行：回到行首，输出提示符和内容，清除行尾剩下的字符，再把光标移回pos
+func (e *lineEditor) draw(prompt string, buf []rune, pos int) {
+	var b strings.Builder
+	b.WriteString("\r")
+	b.WriteString(prompt)
+	b.WriteString(string(buf))
+	b.WriteString("\x1b[K")
+	if back := displayWidth(buf[pos:]); back > 0 {
+		fmt.Fprintf(&b, "\x1b[%dD", back)
+	}
+	e.write(b.String())
+}
+
+func (e *lineEditor) write(s string) {
+	io.WriteString(e.out, s)
+}
+
+// readKey 读取一个按键，方向键等转义序列被转换成对应的按键常量
+func (e *lineEditor) readKey() (rune, error) {
+	r, _, err := e.in.ReadRune()
+	if err != nil || r != keyEscape {
+		return r, err
+	}
+
+	r, _, err = e.in.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	if r != '[' && r != 'O' {
+		return keyUnknown, nil
+	}
+
+	var params []rune //例如 ESC [ 3 ~ 中的3
+	for {
+		r, _, err = e.in.ReadRune()
+		if err != nil {
+			return 0, err
+		}
+		if r >= 0x40 && r <= 0x7e { //转义序列的最后一个字符
+			break
+		}
+		params = append(params, r)
+	}
+
+	switch r {
+	case 'A':
+		return keyUp, nil
+	case 'B':
+		return keyDown, nil
+	case 'C':
+		return keyRight, nil
+	case 'D':
+		return keyLeft, nil
+	case 'H':
+		return keyHome, nil
+	case 'F':
+		return keyEnd, nil
+	case '~':
+		switch string(params) {
+		case "1", "7":
+			return keyHome, nil
+		case "4", "8":
+			return keyEnd, nil
+		case "3":
+			return keyDelete, nil
+		}
+	}
+	return keyUnknown, nil
+}
+
+// isIdentRune 与lexer中的规则一致，标识符由字母、数字和下划线组成
+func isIdentRune(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || r == '_' || '0' <= r && r <= '9'
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// displayWidth 计算字符在终端上占的列数，中文等全角字符占两列
+func displayWidth(rs []rune) int {
+	width := 0
+	for _, r := range rs {
+		if isWide(r) {
+			width += 2
+		} else {
+			width++
+		}
+	}
+	return width
+}
+
+func isWide(r rune) bool {
+	return r >= 0x1100 && r <= 0x115f ||
+		r >= 0x2e80 && r <= 0xa4cf ||
+		r >= 0xac00 && r <= 0xd7a3 ||
+		r >= 0xf900 && r <= 0xfaff ||
+		r >= 0xfe30 && r <= 0xfe4f ||
+		r >= 0xff00 && r <= 0xff60 ||
+		r >= 0xffe0 && r <= 0xffe6 ||
+		r >= 0x20000 && r <= 0x3fffd
+}
@@ -0,0 +1,204 @@
+package repl
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	up    = "\x1b[A"
+	down  = "\x1b[B"
+	right = "\x1b[C"
+	left  = "\x1b[D"
+	home  = "\x1b[H"
+	del   = "\x1b[3~"
+)
+
+func newTestEditor(input string, entries ...string) *lineEditor {
+	return &lineEditor{
+		in:      bufio.NewReader(strings.NewReader(input)),
+		out:     io.Discard,
+		history: &history{entries: entries},
+		complete: func(prefix string) []string {
+			var names []string
+			for _, name := range []string{"fn", "for", "false", "len", "length"} {
+				if strings.HasPrefix(name, prefix) {
+					names = append(names, name)
+				}
+			}
+			return names
+		},
+	}
+}
+
+func TestLineEditorEditing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2\r", "1 + 2"},
+		{"1 + 2\n", "1 + 2"},
+		{"13" + left + "2\r", "123"},
+		{"abc" + left + left + right + "X\r", "abXc"},
+		{"abc\x7f\x7f\r", "a"},
+		{"abc" + home + del + "\r", "bc"},
+		{"abc\x01X\x05Y\r", "XabcY"},
+		{"abc\x02\x02\x0b\r", "a"},
+		{"abc\x02\x15\r", "c"},
+		{"let a = 1\x17\x17\r", "let a "},
+		{"a\x04\x01\x04\r", ""},
+		{"中文" + left + "X\r", "中X文"},
+	}
+
+	for _, tt := range tests {
+		e := newTestEditor(tt.input)
+		line, err := e.readLine(PROMPT)
+		if err != nil {
+			t.Errorf("input %q: unexpected error %v", tt.input, err)
+			continue
+		}
+		if line != tt.expected {
+			t.Errorf("input %q: expected %q, got %q", tt.input, tt.expected, line)
+		}
+	}
+}
+
+func TestLineEditorEOFAndInterrupt(t *testing.T) {
+	e := newTestEditor("\x04")
+	if _, err := e.readLine(PROMPT); err != io.EOF {
+		t.Errorf("Ctrl-D on empty line: expected io.EOF, got %v", err)
+	}
+
+	e = newTestEditor("abc\x03")
+	if _, err := e.readLine(PROMPT); err != errInterrupted {
+		t.Errorf("Ctrl-C: expected errInterrupted, got %v", err)
+	}
+
+	e = newTestEditor("abc")
+	if _, err := e.readLine(PROMPT); err != io.EOF {
+		t.Errorf("end of input: expected io.EOF, got %v", err)
+	}
+}
+
+func TestLineEditorHistory(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{up + "\r", "third"},
+		{up + up + up + up + "\r", "first"},
+		{up + up + down + "\r", "third"},
+		{"new" + up + down + "\r", "new"},
+		{"\x10\x10\x0e\r", "third"},
+		{"\x12sec\r", "second"},
+		{"\x12ir\r", "third"},
+		{"\x12ir\x12\r", "first"},
+		{"\x12ir\x12\x12\r", "first"},
+		{"\x12ir\x7f\x7fs\r", "second"},
+		{"typed\x12sec\x07\r", "typed"},
+		{"\x12sec\x05!\r", "second!"},
+		{"\x12sec" + right + "!\r", "s!econd"},
+		{"\x12zzz\r", ""},
+	}
+
+	for _, tt := range tests {
+		e := newTestEditor(tt.input, "first", "second", "third")
+		line, err := e.readLine(PROMPT)
+		if err != nil {
+			t.Errorf("input %q: unexpected error %v", tt.input, err)
+			continue
+		}
+		if line != tt.expected {
+			t.Errorf("input %q: expected %q, got %q", tt.input, tt.expected, line)
+		}
+	}
+
+	e := newTestEditor("third\rfourth\r", "first", "second", "third")
+	e.readLine(PROMPT)
+	e.readLine(PROMPT)
+	expected := []string{"first", "second", "third", "fourth"}
+	if strings.Join(e.history.entries, ",") != strings.Join(expected, ",") {
+		t.Errorf("wrong history. expected %q, got %q", expected, e.history.entries)
+	}
+}
+
+func TestLineEditorCompletion(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"le\t(x)\r", "len(x)"},
+		{"fa\t\r", "false"},
+		{"f\t\r", "f"},
+		{"lengt\t\r", "length"},
+		{"x = \t\r", "x = "},
+		{"puts(fo\t\r", "puts(for"},
+	}
+
+	for _, tt := range tests {
+		e := newTestEditor(tt.input)
+		line, err := e.readLine(PROMPT)
+		if err != nil {
+			t.Errorf("input %q: unexpected error %v", tt.input, err)
+			continue
+		}
+		if line != tt.expected {
+			t.Errorf("input %q: expected %q, got %q", tt.input, tt.expected, line)
+		}
+	}
+
+	var out bytes.Buffer
+	e := newTestEditor("f\t\r")
+	e.out = &out
+	e.readLine(PROMPT)
+	if !strings.Contains(out.String(), "\r\nfn  for  false\r\n") {
+		t.Errorf("candidates not listed. got=%q", out.String())
+	}
+}
+
+func TestSessionComplete(t *testing.T) {
+	s := newSession(strings.NewReader(""), io.Discard)
+	s.eval("", "let lemon = 1;")
+
+	got := strings.Join(s.complete("le"), " ")
+	if got != "lemon len length let" {
+		t.Errorf("wrong candidates. got=%q", got)
+	}
+}
+
+func TestHistoryFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), historyFileName)
+
+	h := loadHistory(file)
+	h.add("let a = 1;")
+	h.add("let a = 1;")
+	h.add("  ")
+	h.add("a + 1")
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "let a = 1;\na + 1\n" {
+		t.Errorf("wrong file content. got=%q", content)
+	}
+
+	h = loadHistory(file)
+	if strings.Join(h.entries, "|") != "let a = 1;|a + 1" {
+		t.Errorf("wrong entries after reload. got=%q", h.entries)
+	}
+
+	var many strings.Builder
+	for i := 0; i < maxHistory+10; i++ {
+		many.WriteString("x\n")
+	}
+	os.WriteFile(file, []byte(many.String()), 0600)
+	if h = loadHistory(file); len(h.entries) != maxHistory {
+		t.Errorf("expected %d entries, got %d", maxHistory, len(h.entries))
+	}
+}
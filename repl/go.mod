@@ -18,6 +18,9 @@ require (
     my.com/myfile/ast v0.0.0
     my.com/myfile/evaluator v0.0.0
     my.com/myfile/object v0.0.0
+    my.com/myfile/code v0.0.0
+    my.com/myfile/compiler v0.0.0
+    my.com/myfile/vm v0.0.0
 )
 
 replace (
@@ -27,5 +30,8 @@ replace (
     my.com/myfile/ast => ../ast
     my.com/myfile/evaluator => ../evaluator
     my.com/myfile/object => ../object
+    my.com/myfile/code => ../code
+    my.com/myfile/compiler => ../compiler
+    my.com/myfile/vm => ../vm
 )
 
@@ -0,0 +1,73 @@
+package repl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	historyFileName = ".wizard_history" //保存在用户主目录下
+	maxHistory      = 1000              //最多保留的历史记录条数
+)
+
+// history 保存输入过的行，每加入一行就追加到文件中，下次启动REPL时读取
+type history struct {
+	entries []string
+	file    string //为空时不保存到文件
+}
+
+func historyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, historyFileName)
+}
+
+// loadHistory 读取历史记录文件，文件不存在时返回空的历史记录。
+// 超过maxHistory条时只保留最新的部分，并重写文件
+func loadHistory(file string) *history {
+	h := &history{file: file}
+	if file == "" {
+		return h
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return h
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			h.entries = append(h.entries, line)
+		}
+	}
+	if len(h.entries) > maxHistory {
+		h.entries = h.entries[len(h.entries)-maxHistory:]
+		os.WriteFile(file, []byte(strings.Join(h.entries, "\n")+"\n"), 0600)
+	}
+	return h
+}
+
+// add 加入一行，空行和与上一条相同的行不记录
+func (h *history) add(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if n := len(h.entries); n > 0 && h.entries[n-1] == line {
+		return
+	}
+	h.entries = append(h.entries, line)
+	if len(h.entries) > maxHistory {
+		h.entries = h.entries[1:]
+	}
+
+	if h.file == "" {
+		return
+	}
+	f, err := os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return //历史记录只是辅助功能，写入失败时忽略
+	}
+	defer f.Close()
+	f.WriteString(line + "\n")
+}
@@ -1,72 +1,287 @@
 package repl
 
 import (
-	"bufio"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 
+	"my.com/myfile/ast"
 	"my.com/myfile/evaluator"
 	"my.com/myfile/lexer"
 	"my.com/myfile/object"
 	"my.com/myfile/parser"
+	"my.com/myfile/token"
+	"my.com/myfile/vm"
 )
 
-const PROMPT = ">> "
+const (
+	PROMPT          = ">> "
+	CONTINUE_PROMPT = ".. " //语句还没有输入完整时的提示符
+)
+
+// 执行输入的方式，用 :backend 切换
+const (
+	BackendEval = "eval" //遍历语法树求值
+	BackendVM   = "vm"   //编译成字节码，由虚拟机执行
+)
+
+// session 保存一次REPL会话的状态
+type session struct {
+	out      io.Writer
+	runtime  *object.Runtime //脚本的输入输出，puts的输出和REPL的输出写到同一个地方
+	env      *object.Environment
+	accepted []string //成功执行的输入，:save 时写入文件
+	quit     bool     //输入了 :quit
+	backend  string
+}
+
+func newSession(in io.Reader, out io.Writer) *session {
+	rt := &object.Runtime{Stdin: in, Stdout: out, Stderr: out}
+	return &session{out: out, runtime: rt, env: object.NewEnvironmentWithRuntime(rt), backend: BackendEval}
+}
 
 func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in) //创建一个新的扫描器
-	env := object.NewEnvironment()
-	input := "" //初始化
-
-	fmt.Fprintf(out, PROMPT)
-	for scanner.Scan() { //
-		line := scanner.Text() //当用户输入一行并按下Enter键时，scanner.Text()会获取该行文本。
-		input += line + "\n"   //累加到input字符串，直到输入被视为完整
-
-		if IsComplete(input) { // 当输入两个空行后，进入if语句，值得注意的是，虽然实在for循环中，一个完整的语句只经历一次if语句中的程序
-			l := lexer.New(input) //得到一个lexer结构体指针
-			p := parser.New(l)    //在parser.New()中得到一个Parser结构体的指针，将其中的成员l初始化为参数l，并且创建token类型与解析函数的映射，方便遇到特定类型的token时调用
-
-			program := p.ParseProgram() //创建一个ast.program结构体，并且创建所有的ast.steatment，也就是创建一个抽象语法树
-			if len(p.Errors()) != 0 {
-				printParserErrors(out, p.Errors()) //错误会通过printParserErrors函数输出到out
-			} else {
-				evaluated := evaluator.Eval(program, env) //返回一个Object接口
-				if evaluated != nil {
-					inspectedValue := evaluated.Inspect()
-					if inspectedValue != "null" {
-						io.WriteString(out, inspectedValue) //使用Inspect计算
-						io.WriteString(out, "\n")
-					} else {
-						io.WriteString(out, "\n")
-					}
-				}
+	StartWithBackend(in, out, BackendEval)
+}
+
+// StartWithBackend 与Start相同，但是用backend(BackendEval或BackendVM)执行输入
+func StartWithBackend(in io.Reader, out io.Writer, backend string) {
+	s := newSession(in, out)
+	s.backend = backend
+	reader := s.newLineReader(in) //终端上使用行编辑器，否则逐行读取
+	input := ""                   //初始化
+	pending := ""                 //以 } 结尾、下一行可能以else、catch或finally接着写的语句
+	prompt := PROMPT
+
+	for {
+		line, err := reader.readLine(prompt) //当用户输入一行并按下Enter键时返回该行文本
+		if err == errInterrupted {           //Ctrl-C丢弃还没有执行的输入
+			input, pending, prompt = "", "", PROMPT
+			continue
+		}
+		if err == io.EOF {
+			if pending != "" {
+				s.eval("", pending)
+			}
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(out, "error: %v\n", err)
+			return
+		}
+
+		if pending != "" {
+			if continuesPrevious(line) {
+				input = pending
+			} else { //下一行是新的语句，先执行之前的语句
+				s.eval("", pending)
+				prompt = PROMPT
+			}
+			pending = ""
+		}
+
+		if input == "" && strings.TrimSpace(line) == "" {
+			continue
+		}
+		if input == "" && isCommand(line) { //以冒号开头的是REPL命令，例如 :help
+			s.runCommand(line)
+			if s.quit {
+				return
 			}
+			continue
+		}
+		input += line + "\n" //累加到input字符串，直到输入被视为完整
+
+		// 输入不完整时继续读取，连续输入两个空行可以强制执行，避免因为多余的括号无法退出
+		if !IsComplete(input) && !strings.HasSuffix(input, "\n\n\n") {
+			prompt = CONTINUE_PROMPT
+			continue
+		}
+		if mayContinue(input) { //等下一行确定语句是否结束，空行直接执行
+			pending, input = input, ""
+			prompt = CONTINUE_PROMPT
+			continue
+		}
+
+		s.eval("", input)
+
+		input, prompt = "", PROMPT // 清空输入以准备接受下一个完整的语句
+	}
+}
+
+// eval 解析并执行一段输入，打印结果。执行成功的输入会被记录下来
+func (s *session) eval(filename, input string) {
+	l := lexer.NewFile(filename, input) //得到一个lexer结构体指针
+	p := parser.New(l)                  //在parser.New()中得到一个Parser结构体的指针，将其中的成员l初始化为参数l，并且创建token类型与解析函数的映射，方便遇到特定类型的token时调用
+
+	program := p.ParseProgram() //创建一个ast.program结构体，并且创建所有的ast.steatment，也就是创建一个抽象语法树
+	if len(p.Errors()) != 0 {
+		printParserErrors(s.out, p.Diagnostics()) //错误会通过printParserErrors函数输出到out
+		return
+	}
 
-			input = "" // 清空输入以准备接受下一个完整的语句
-			fmt.Fprintf(out, PROMPT)
+	evaluated := s.run(program) //返回一个Object接口
+	if errObj, ok := evaluated.(*object.Error); ok {
+		io.WriteString(s.out, errObj.Inspect()+"\n")
+		io.WriteString(s.out, errObj.Traceback()) //错误经过函数调用时打印调用栈
+		return
+	}
+	s.accepted = append(s.accepted, strings.TrimRight(input, "\n"))
+	if evaluated != nil {
+		inspectedValue := evaluated.Inspect()
+		if inspectedValue != "null" {
+			io.WriteString(s.out, inspectedValue) //使用Inspect计算
+			io.WriteString(s.out, "\n")
+		} else {
+			io.WriteString(s.out, "\n")
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintf(out, "error: %v\n", err)
+}
+
+// run 用当前的执行方式执行program
+func (s *session) run(program *ast.Program) object.Object {
+	if s.backend == BackendVM {
+		return vm.Eval(program, s.env)
 	}
+	return evaluator.Eval(program, s.env)
 }
-func printParserErrors(out io.Writer, errors []string) { //错误输出
+
+// complete 返回以prefix开头的关键字、内置函数和当前环境中的变量，用于Tab补全
+func (s *session) complete(prefix string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, list := range [][]string{token.Keywords(), evaluator.BuiltinNames(), s.env.Names()} {
+		for _, name := range list {
+			if strings.HasPrefix(name, prefix) && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func printParserErrors(out io.Writer, errors []*lexer.Error) { //错误输出，每个错误后面是出错的源码和标出位置的^
 	io.WriteString(out, " parser errors:\n")
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+	for _, err := range errors {
+		io.WriteString(out, "\t"+err.Error()+"\n")
+		if excerpt := err.Excerpt(); excerpt != "" {
+			io.WriteString(out, "\t"+strings.ReplaceAll(excerpt, "\n", "\n\t")+"\n")
+		}
+	}
+}
+
+// IsComplete 用词法分析器判断输入是否完整：
+// 括号都已闭合，字符串和块注释已经结束，并且最后一个token后面不需要再跟其他内容
+func IsComplete(input string) bool {
+	l := lexer.New(input)
+	depth := 0
+	var last token.Token
+
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.LPAREN, token.LBRACE, token.LBRACKET:
+			depth++
+		case token.RPAREN, token.RBRACE, token.RBRACKET:
+			depth-- //多余的右括号交给parser报错
+		}
+		last = tok
+	}
+
+	if l.Unterminated() || depth > 0 {
+		return false
 	}
+	return !continuesStatement[last.Type]
 }
 
-func IsComplete(input string) bool { //判断完整性，但是你在程序中间不能空两行，因为那样会被视为结束
-	// 将输入字符串拆分成行
-	lines := strings.Split(input, "\n")
+// mayContinue 判断完整的输入是否以if、try或catch的块结尾，这样的语句可以在下一行用else、catch或finally继续
+func mayContinue(input string) bool {
+	l := lexer.New(input)
+	depth := 0
+	var prev, beforeParen, opener, last token.Token
 
-	// 获取最后两行
-	lastLine := lines[len(lines)-1]
-	secondLastLine := lines[len(lines)-2]
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.RPAREN, token.RBRACE, token.RBRACKET:
+			depth--
+		}
+		if depth == 0 { //只看最外层的token
+			switch tok.Type {
+			case token.LPAREN:
+				beforeParen = prev
+			case token.LBRACE: //记录块前面的关键字，条件的括号跳过
+				opener = prev
+				if prev.Type == token.RPAREN {
+					opener = beforeParen
+				}
+			}
+			prev = tok
+		}
+		switch tok.Type {
+		case token.LPAREN, token.LBRACE, token.LBRACKET:
+			depth++
+		}
+		last = tok
+	}
+
+	if last.Type != token.RBRACE || depth != 0 {
+		return false
+	}
+	return opener.Type == token.IF || opener.Type == token.TRY || opener.Type == token.CATCH
+}
+
+// continuesPrevious 判断一行是否以else、catch或finally开头，接着上一个语句
+func continuesPrevious(line string) bool {
+	switch lexer.New(line).NextToken().Type {
+	case token.ELSE, token.CATCH, token.FINALLY:
+		return true
+	}
+	return false
+}
 
-	// 如果最后两行都是空字符串，则认为是完整的语句
-	return strings.TrimSpace(lastLine) == "" && strings.TrimSpace(secondLastLine) == ""
+// continuesStatement 出现在行尾时说明语句还没有结束的token，例如二元运算符和逗号
+var continuesStatement = map[token.TokenType]bool{
+	token.ASSIGN:          true,
+	token.PLUS_ASSIGN:     true,
+	token.MINUS_ASSIGN:    true,
+	token.ASTERISK_ASSIGN: true,
+	token.SLASH_ASSIGN:    true,
+	token.PLUS:            true,
+	token.MINUS:           true,
+	token.BANG:            true,
+	token.ASTERISK:        true,
+	token.SLASH:           true,
+	token.PERCENT:         true,
+	token.POWER:           true,
+	token.EQ:              true,
+	token.NOT_EQ:          true,
+	token.LT:              true,
+	token.GT:              true,
+	token.LE:              true,
+	token.GE:              true,
+	token.AND:             true,
+	token.OR:              true,
+	token.BIT_AND:         true,
+	token.BIT_OR:          true,
+	token.BIT_XOR:         true,
+	token.BIT_NOT:         true,
+	token.SHL:             true,
+	token.SHR:             true,
+	token.DOTDOT:          true,
+	token.DOTDOT_EQ:       true,
+	token.IN:              true,
+	token.COMMA:           true,
+	token.COLON:           true,
+	token.LET:             true,
+	token.FUNCTION:        true,
+	token.IF:              true,
+	token.ELSE:            true,
+	token.WHILE:           true,
+	token.FOR:             true,
+	token.TRY:             true,
+	token.CATCH:           true,
+	token.FINALLY:         true,
+	token.THROW:           true,
 }
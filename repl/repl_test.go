@@ -0,0 +1,229 @@
+package repl
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsComplete(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"1 + 2\n", true},
+		{"let a = 5;\n", true},
+		{"let f = fn(x) {\n", false},
+		{"let f = fn(x) {\n\n  x\n", false},
+		{"let f = fn(x) {\n\n  x\n}\n", true},
+		{"[1, 2,\n", false},
+		{"puts(\"a\",\n", false},
+		{"1 +\n", false},
+		{"a &&\n", false},
+		{"let x =\n", false},
+		{"\"unterminated\n", false},
+		{"\"multi\nline\"\n", true},
+		{"/* comment\n", false},
+		{"/* comment */ 1\n", true},
+		{"1 // comment (\n", true},
+		{"if (x) { 1 } else\n", false},
+		{"outer:\n", false},
+		{")\n", true},
+	}
+
+	for _, tt := range tests {
+		if got := IsComplete(tt.input); got != tt.expected {
+			t.Errorf("IsComplete(%q) = %t, want %t", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestMayContinue(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"if (x) { 1 }\n", true},
+		{"let y = if (x) {\n  1\n}\n", true},
+		{"if (x) { 1 } else if (y) { 2 }\n", true},
+		{"if (x) { 1 } else { 2 }\n", false},
+		{"if (x) { 1 };\n", false},
+		{"try { f() }\n", true},
+		{"try { f() } catch (e) { e }\n", true},
+		{"try { f() } finally { g() }\n", false},
+		{"let f = fn(x) { if (x) { 1 } }\n", false},
+		{"while (x) { x -= 1 }\n", false},
+		{"{\"a\": 1}\n", false},
+		{"1 + 2\n", false},
+	}
+
+	for _, tt := range tests {
+		if got := mayContinue(tt.input); got != tt.expected {
+			t.Errorf("mayContinue(%q) = %t, want %t", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestContinuationLines(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"if (false) {\n  1\n}\nelse {\n  2\n}\n", ">> .. .. .. .. .. 2\n>> "},
+		{"try {\n  throw 1\n}\ncatch (e) {\n  \"caught\"\n}\nfinally {\n  puts(3)\n}\n", ">> .. .. .. .. .. .. .. .. 3caught\n>> "},
+		{"if (true) { 1 }\n2\n", ">> .. 1\n2\n>> "},
+		{"if (true) { 1 }\n\n", ">> .. 1\n>> "},
+		{"if (true) { 1 }\n", ">> .. 1\n"},
+		{"if (true) { 1 }\n:env\n", ">> .. 1\n"},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		Start(strings.NewReader(tt.input), &out)
+		if !strings.HasPrefix(out.String(), tt.expected) {
+			t.Errorf("input %q: wrong output.\nexpected prefix=%q\ngot=%q", tt.input, tt.expected, out.String())
+		}
+	}
+}
+
+func TestStartMultiLineInput(t *testing.T) {
+	input := `let add = fn(a, b) {
+
+  a + b
+}
+add(1,
+  2)
+1 + 2
+
+((
+
+
+`
+	var out bytes.Buffer
+	Start(strings.NewReader(input), &out)
+
+	expected := ">> .. .. .. >> .. 3\n>> 3\n>> >> .. ..  parser errors:\n"
+	if !strings.HasPrefix(out.String(), expected) {
+		t.Errorf("wrong output.\nexpected prefix=%q\ngot=%q", expected, out.String())
+	}
+}
+
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+	loaded := filepath.Join(dir, "lib.wz")
+	if err := os.WriteFile(loaded, []byte("let double = fn(x) { x * 2 };\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    string
+		expected []string //输出中应该包含的内容
+	}{
+		{":help\n", []string{":tokens <code>", ":quit"}},
+		{"let a = 1;\nlet b = \"x\";\n:env\n", []string{"a = 1\n", "b = x\n"}},
+		{":tokens let x = 5;\n", []string{"1:1    LET        \"let\"\n", "1:9    INT        \"5\"\n", "1:11   EOF        \"\"\n"}},
+		{":ast 1 + 2\n", []string{"*ast.InfixExpression (1:3) Operator=\"+\"\n", "          Left:\n            *ast.IntegerLiteral (1:1) Value=1\n"}},
+		{":ast let = \n", []string{" parser errors:\n"}},
+		{":type 1 + 2\n:type \"a\"\n:type fn(x) { x }\n", []string{"INTEGER\n", "STRING\n", "FUNCTION\n"}},
+		{":type\n", []string{"usage: :type <expr>\n"}},
+		{":load " + loaded + "\ndouble(21)\n", []string{"42\n"}},
+		{":load " + filepath.Join(dir, "missing.wz") + "\n", []string{"error: "}},
+		{"let a = 1;\n:reset\na\n", []string{"identifier not found: a"}},
+		{":bogus\n", []string{"unknown command :bogus"}},
+		{":quit\n1 + 1\n", []string{">> "}},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		Start(strings.NewReader(tt.input), &out)
+		for _, want := range tt.expected {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("input %q: output does not contain %q.\ngot=%q", tt.input, want, out.String())
+			}
+		}
+	}
+}
+
+func TestQuitStopsReading(t *testing.T) {
+	var out bytes.Buffer
+	Start(strings.NewReader(":quit\n1 + 1\n"), &out)
+	if out.String() != ">> " {
+		t.Errorf("wrong output after :quit. got=%q", out.String())
+	}
+}
+
+func TestSaveAcceptedInput(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "session.wz")
+	input := "let a = 1;\nlet f = fn(x) {\n  x + a\n}\nundefined\nlet = 3\n:save " + filename + "\n"
+
+	var out bytes.Buffer
+	Start(strings.NewReader(input), &out)
+
+	if !strings.Contains(out.String(), "saved 2 entries to "+filename) {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "let a = 1;\nlet f = fn(x) {\n  x + a\n}\n"
+	if string(content) != expected {
+		t.Errorf("wrong file content.\nexpected=%q\ngot=%q", expected, content)
+	}
+}
+
+func TestPutsWritesToOutput(t *testing.T) {
+	var out bytes.Buffer
+	Start(strings.NewReader("puts(\"hello\")\n"), &out)
+
+	if out.String() != ">> hello\n>> " {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+}
+
+func TestErrorTraceback(t *testing.T) {
+	var out bytes.Buffer
+	Start(strings.NewReader("let f = fn() { 1 / 0 };\nf()\n"), &out)
+
+	expected := ">> >> ERROR: 1:18: division by zero: 1 / 0\n" +
+		"traceback (most recent call first):\n" +
+		"  in f, called at 1:1\n>> "
+	if out.String() != expected {
+		t.Errorf("wrong output.\nexpected=%q\ngot=%q", expected, out.String())
+	}
+}
+
+func TestBackendCommand(t *testing.T) {
+	input := "let a = 1;\n:backend\n:backend vm\na\nlet f = fn(x) { x * 2 }\nf(21)\n:backend vm\n:backend bogus\n:backend eval\nf\n"
+	var out bytes.Buffer
+	Start(strings.NewReader(input), &out)
+
+	for _, want := range []string{
+		"backend: eval\n",
+		"switched to vm, bindings cleared\n",
+		"identifier not found: a",
+		"42\n",
+		"already using vm\n",
+		"unknown backend bogus, expected eval or vm\n",
+		"switched to eval, bindings cleared\n",
+		"identifier not found: f",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q.\ngot=%q", want, out.String())
+		}
+	}
+}
+
+func TestErrorTracebackOnVM(t *testing.T) {
+	var out bytes.Buffer
+	StartWithBackend(strings.NewReader("let f = fn() { 1 / 0 };\nf()\n"), &out, BackendVM)
+
+	expected := ">> >> ERROR: 1:18: division by zero: 1 / 0\n" +
+		"traceback (most recent call first):\n" +
+		"  in f, called at 1:1\n>> "
+	if out.String() != expected {
+		t.Errorf("wrong output.\nexpected=%q\ngot=%q", expected, out.String())
+	}
+}
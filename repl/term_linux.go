@@ -0,0 +1,11 @@
+//go:build linux
+
+package repl
+
+import "syscall"
+
+// Linux上读取和修改终端设置的ioctl请求
+const (
+	ioctlGetTermios = syscall.TCGETS
+	ioctlSetTermios = syscall.TCSETS
+)
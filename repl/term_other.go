@@ -0,0 +1,20 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package repl
+
+import "errors"
+
+// 其他系统上没有实现raw模式，REPL退回到逐行读取
+type termState struct{}
+
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (*termState, error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
+
+func restore(fd int, state *termState) error {
+	return nil
+}
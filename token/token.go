@@ -1,15 +1,48 @@
 package token
 
+import (
+	"fmt"
+	"sort"
+)
+
 type TokenType string
 
 type Token struct {
 	Type    TokenType //token的类型
 	Literal string    //token的值
+	Pos     Position  //token第一个字符的位置
+	End     Position  //token之后第一个字符的位置
+}
+
+// Position 描述源码中的一个位置，Line和Column从1开始，Offset从0开始
+type Position struct {
+	Filename string
+	Offset   int //字节偏移
+	Line     int //行号
+	Column   int //列号，按字符计算
+}
+
+// IsValid 位置是否有效，手动构造的Token没有位置信息
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+// String 返回 file:line:col 形式的位置，没有文件名时返回 line:col
+func (p Position) String() string {
+	if !p.IsValid() {
+		if p.Filename != "" {
+			return p.Filename
+		}
+		return "-"
+	}
+	if p.Filename == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
 }
 
 const (
 	ILLEGAL = "ILLEGAL"
 	EOF     = "EOF"
+	COMMENT = "COMMENT" //注释，不会交给parser，而是作为附加信息保存
 
 	// 标识符+字面量
 	ID    = "ID"
@@ -17,7 +50,12 @@ const (
 	FLOAT = "FLOAT"
 
 	// 运算符
-	ASSIGN   = "="
+	ASSIGN          = "="
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
+
 	PLUS     = "+"
 	MINUS    = "-"
 	BANG     = "!"
@@ -27,10 +65,23 @@ const (
 	NOT_EQ   = "!="
 	GE       = ">="
 	LE       = "<="
+	AND      = "&&" //也可以写作 and
+	OR       = "||" //也可以写作 or
+	PERCENT  = "%"
+	POWER    = "**"
+	BIT_AND  = "&"
+	BIT_OR   = "|"
+	BIT_XOR  = "^"
+	BIT_NOT  = "~"
+	SHL      = "<<"
+	SHR      = ">>"
 
 	LT = "<"
 	GT = ">"
 
+	DOTDOT    = ".."  //区间，0..10不包括10
+	DOTDOT_EQ = "..=" //包括终点的区间，0..=10
+
 	// 分隔符
 	COMMA     = ","
 	SEMICOLON = ";"
@@ -58,6 +109,11 @@ const (
 	WHILE    = "WHILE"
 	STRING   = "STRING"
 	FOR      = "for"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	THROW    = "THROW"
+	IN       = "in"
 )
 
 // 判断是否是关键字
@@ -74,6 +130,13 @@ var keywords = map[string]TokenType{
 	"continue": CONTINUE,
 	"break":    BREAK,
 	"for":      FOR,
+	"and":      AND,
+	"or":       OR,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
+	"throw":    THROW,
+	"in":       IN,
 }
 
 // LookupId 查找关键字，如果不是关键字则返回ID
@@ -84,3 +147,13 @@ func LookupId(id string) TokenType {
 	}
 	return ID
 }
+
+// Keywords 返回所有关键字，按字母顺序排列，用于REPL的Tab补全
+func Keywords() []string {
+	names := make([]string, 0, len(keywords))
+	for name := range keywords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,583 @@
+// Package vm 执行compiler生成的字节码。
+// 全局变量保存在object.Environment中，所以vm和求值器可以共用同一个环境和同一套内置函数
+package vm
+
+import (
+	"fmt"
+
+	"my.com/myfile/ast"
+	"my.com/myfile/code"
+	"my.com/myfile/compiler"
+	"my.com/myfile/evaluator"
+	"my.com/myfile/object"
+)
+
+const initialStackSize = 1024
+
+// infixOperators 二元运算的指令对应的运算符，整数以外的运算交给求值器计算
+var infixOperators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpMod:          "%",
+	code.OpPow:          "**",
+	code.OpBitAnd:       "&",
+	code.OpBitOr:        "|",
+	code.OpBitXor:       "^",
+	code.OpShl:          "<<",
+	code.OpShr:          ">>",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+	code.OpLess:         "<",
+	code.OpGreater:      ">",
+	code.OpLessEqual:    "<=",
+	code.OpGreaterEqual: ">=",
+	code.OpRange:        "..",
+
+	code.OpRangeInclusive: "..=",
+}
+
+var prefixOperators = map[code.Opcode]string{
+	code.OpMinus:  "-",
+	code.OpBang:   "!",
+	code.OpBitNot: "~",
+}
+
+type VM struct {
+	env  *object.Environment //全局变量
+	rt   *object.Runtime
+	main *object.Closure
+
+	stack []object.Object
+	sp    int //栈顶的下一个位置
+
+	frames   []frame
+	handlers []handler //正在生效的try，最内层的在最后
+}
+
+// frame 一次函数调用，局部变量保存在栈中从basePointer开始的位置
+type frame struct {
+	cl          *object.Closure
+	ip          int //下一条指令的位置
+	basePointer int
+}
+
+// handler OpSetupTry记录的错误处理位置
+type handler struct {
+	frame int //try所在的栈帧
+	addr  int //catch或finally的代码
+	sp    int //进入try时栈的高度
+}
+
+// iterator for-in的迭代器，保存在隐藏的局部变量中，脚本中无法访问
+type iterator struct {
+	object.Iterator
+}
+
+func (it *iterator) Type() object.ObjectType { return "ITERATOR" }
+func (it *iterator) Inspect() string         { return "iterator" }
+
+// New 创建执行bytecode的虚拟机，全局变量保存在env中
+func New(bytecode *compiler.Bytecode, env *object.Environment) *VM {
+	return &VM{
+		env:   env,
+		rt:    env.Runtime(),
+		main:  &object.Closure{Fn: bytecode.Main},
+		stack: make([]object.Object, initialStackSize),
+	}
+}
+
+// Eval 编译并执行program，返回值与evaluator.Eval相同：
+// 程序最后一个表达式的值，最后一个语句不是表达式时为nil，发生错误时为*object.Error
+func Eval(program *ast.Program, env *object.Environment) object.Object {
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		return &object.Error{Kind: object.SYNTAX_ERROR, Message: err.Error()}
+	}
+	return New(c.Bytecode(), env).Run()
+}
+
+// Run 执行程序。与求值器一样，执行受Runtime的限制，Go panic会被转换为*object.Error
+func (vm *VM) Run() (result object.Object) {
+	defer vm.rt.Begin()()
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError(object.INTERNAL_ERROR, "internal error: %v", r)
+		}
+	}()
+
+	vm.sp = vm.main.Fn.NumLocals
+	vm.frames = append(vm.frames[:0], frame{cl: vm.main})
+	vm.handlers = vm.handlers[:0]
+	vm.grow(vm.sp)
+	clear(vm.stack[:vm.sp])
+
+	return vm.run()
+}
+
+func (vm *VM) run() object.Object {
+	for {
+		f := &vm.frames[len(vm.frames)-1]
+		ins := f.cl.Fn.Instructions
+		start := f.ip
+		op := code.Opcode(ins[start])
+		f.ip++
+
+		if stepErr := vm.rt.Step(); stepErr != nil { //超过执行限制或者被取消
+			err := newError(object.LIMIT_ERROR, "%s", stepErr)
+			vm.raise(err, start)
+			return err
+		}
+
+		var err *object.Error
+		switch op {
+		case code.OpConstant:
+			vm.push(f.cl.Fn.Constants[vm.readUint16(f)])
+
+		case code.OpNull:
+			vm.push(evaluator.NULL)
+
+		case code.OpTrue:
+			vm.push(evaluator.TRUE)
+
+		case code.OpFalse:
+			vm.push(evaluator.FALSE)
+
+		case code.OpPop:
+			vm.sp--
+
+		case code.OpDup:
+			vm.push(vm.stack[vm.sp-1])
+
+		case code.OpDup2:
+			vm.push(vm.stack[vm.sp-2])
+			vm.push(vm.stack[vm.sp-2])
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpPow,
+			code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShl, code.OpShr,
+			code.OpEqual, code.OpNotEqual, code.OpLess, code.OpGreater, code.OpLessEqual, code.OpGreaterEqual,
+			code.OpRange, code.OpRangeInclusive:
+			right, left := vm.stack[vm.sp-1], vm.stack[vm.sp-2]
+			vm.sp -= 2
+			result := vm.infix(op, left, right)
+			if e, ok := result.(*object.Error); ok {
+				err = e
+				break
+			}
+			vm.push(result)
+
+		case code.OpMinus, code.OpBang, code.OpBitNot:
+			result := evaluator.PrefixOperation(prefixOperators[op], vm.stack[vm.sp-1])
+			if e, ok := result.(*object.Error); ok {
+				err = e
+				break
+			}
+			vm.stack[vm.sp-1] = result
+
+		case code.OpJump:
+			f.ip = vm.readUint16(f)
+
+		case code.OpJumpNotTruthy:
+			target := vm.readUint16(f)
+			vm.sp--
+			if !evaluator.IsTruthy(vm.stack[vm.sp]) {
+				f.ip = target
+			}
+
+		case code.OpJumpFalsyKeep, code.OpJumpTruthyKeep:
+			target := vm.readUint16(f)
+			if evaluator.IsTruthy(vm.stack[vm.sp-1]) == (op == code.OpJumpTruthyKeep) {
+				f.ip = target
+			} else {
+				vm.sp--
+			}
+
+		case code.OpGetGlobal:
+			name := f.cl.Fn.Constants[vm.readUint16(f)].(*object.String).Value
+			if val, ok := vm.env.Get(name); ok {
+				vm.push(val)
+			} else if builtin, ok := evaluator.LookupBuiltin(name); ok {
+				vm.push(builtin)
+			} else {
+				err = newError(object.NAME_ERROR, "identifier not found: %s", name)
+			}
+
+		case code.OpDefineGlobal:
+			name := f.cl.Fn.Constants[vm.readUint16(f)].(*object.String).Value
+			vm.sp--
+			vm.env.Set(name, vm.stack[vm.sp])
+
+		case code.OpSetGlobal:
+			name := f.cl.Fn.Constants[vm.readUint16(f)].(*object.String).Value
+			vm.sp--
+			if !vm.env.Assign(name, vm.stack[vm.sp]) {
+				err = newError(object.NAME_ERROR, "assignment to undeclared variable: %s", name)
+			}
+
+		case code.OpGetLocal:
+			i := vm.readUint16(f)
+			val := vm.stack[f.basePointer+i]
+			if val == nil {
+				err = newError(object.NAME_ERROR, "identifier not found: %s", f.cl.Fn.LocalNames[i])
+				break
+			}
+			vm.push(val)
+
+		case code.OpSetLocal:
+			i := vm.readUint16(f)
+			vm.sp--
+			vm.stack[f.basePointer+i] = vm.stack[vm.sp]
+
+		case code.OpGetCell:
+			i := vm.readUint16(f)
+			cell := vm.cell(f.basePointer + i)
+			if cell.Value == nil {
+				err = newError(object.NAME_ERROR, "identifier not found: %s", f.cl.Fn.LocalNames[i])
+				break
+			}
+			vm.push(cell.Value)
+
+		case code.OpSetCell:
+			i := vm.readUint16(f)
+			vm.sp--
+			vm.cell(f.basePointer + i).Value = vm.stack[vm.sp]
+
+		case code.OpLoadCell:
+			vm.push(vm.cell(f.basePointer + vm.readUint16(f)))
+
+		case code.OpGetFree:
+			i := vm.readUint16(f)
+			cell := f.cl.Free[i]
+			if cell.Value == nil {
+				err = newError(object.NAME_ERROR, "identifier not found: %s", f.cl.Fn.FreeNames[i])
+				break
+			}
+			vm.push(cell.Value)
+
+		case code.OpSetFree:
+			i := vm.readUint16(f)
+			cell := f.cl.Free[i]
+			if cell.Value == nil { //外层函数中的let还没有执行
+				err = newError(object.NAME_ERROR, "assignment to undeclared variable: %s", f.cl.Fn.FreeNames[i])
+				break
+			}
+			vm.sp--
+			cell.Value = vm.stack[vm.sp]
+
+		case code.OpLoadFree:
+			vm.push(f.cl.Free[vm.readUint16(f)])
+
+		case code.OpClearLocals:
+			first := f.basePointer + vm.readUint16(f)
+			count := vm.readUint16(f)
+			clear(vm.stack[first : first+count])
+
+		case code.OpArray:
+			n := vm.readUint16(f)
+			elements := make([]object.Object, n)
+			copy(elements, vm.stack[vm.sp-n:vm.sp])
+			vm.sp -= n
+			vm.push(&object.Array{Elements: elements})
+
+		case code.OpHash:
+			n := vm.readUint16(f)
+			hash, e := buildHash(vm.stack[vm.sp-2*n : vm.sp])
+			if e != nil {
+				err = e
+				break
+			}
+			vm.sp -= 2 * n
+			vm.push(hash)
+
+		case code.OpIndex:
+			index, left := vm.stack[vm.sp-1], vm.stack[vm.sp-2]
+			vm.sp -= 2
+			result := evaluator.IndexOperation(left, index)
+			if e, ok := result.(*object.Error); ok {
+				err = e
+				break
+			}
+			vm.push(result)
+
+		case code.OpSlice:
+			high, low, left := vm.stack[vm.sp-1], vm.stack[vm.sp-2], vm.stack[vm.sp-3]
+			vm.sp -= 3
+			result := evaluator.SliceOperation(left, low, high)
+			if e, ok := result.(*object.Error); ok {
+				err = e
+				break
+			}
+			vm.push(result)
+
+		case code.OpIterator:
+			it, e := evaluator.Iterate(vm.stack[vm.sp-1])
+			if e != nil {
+				err = e
+				break
+			}
+			vm.stack[vm.sp-1] = &iterator{it}
+
+		case code.OpIterNext:
+			target := vm.readUint16(f)
+			it := vm.stack[f.basePointer+vm.readUint16(f)].(*iterator)
+			key, value, ok := it.Next()
+			if !ok {
+				f.ip = target
+				break
+			}
+			vm.push(key)
+			vm.push(value)
+
+		case code.OpCheckIndex:
+			err = evaluator.CheckIndexAssignment(vm.stack[vm.sp-2], vm.stack[vm.sp-1])
+
+		case code.OpSetIndex:
+			val, index, left := vm.stack[vm.sp-1], vm.stack[vm.sp-2], vm.stack[vm.sp-3]
+			vm.sp -= 3
+			evaluator.SetIndex(left, index, val)
+			vm.push(val)
+
+		case code.OpClosure:
+			fn := f.cl.Fn.Constants[vm.readUint16(f)].(*object.CompiledFunction)
+			numFree := vm.readUint16(f)
+			free := make([]*object.Cell, numFree)
+			for i := range free {
+				free[i] = vm.stack[vm.sp-numFree+i].(*object.Cell)
+			}
+			vm.sp -= numFree
+			vm.push(&object.Closure{Fn: fn, Free: free})
+
+		case code.OpCall:
+			err = vm.call(vm.readUint8(f))
+
+		case code.OpReturnValue:
+			vm.sp--
+			result := vm.stack[vm.sp]
+			if len(vm.frames) == 1 {
+				return result
+			}
+			vm.sp = f.basePointer - 1 //同时移除被调用的函数
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			vm.rt.ExitCall()
+			vm.push(result)
+
+		case code.OpReturnNil:
+			return nil
+
+		case code.OpThrow:
+			vm.sp--
+			err = evaluator.ThrowValue(vm.stack[vm.sp])
+
+		case code.OpRethrow:
+			vm.sp--
+			err = vm.stack[vm.sp].(*object.Error)
+
+		case code.OpSetupTry:
+			addr := vm.readUint16(f)
+			vm.handlers = append(vm.handlers, handler{frame: len(vm.frames) - 1, addr: addr, sp: vm.sp})
+
+		case code.OpPopTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		case code.OpErrorValue:
+			vm.stack[vm.sp-1] = &object.ErrorValue{Err: vm.stack[vm.sp-1].(*object.Error)}
+
+		case code.OpMark:
+			vm.stack[f.basePointer+vm.readUint16(f)] = &object.Integer{Value: int64(vm.sp)}
+
+		case code.OpUnwind:
+			vm.sp = int(vm.stack[f.basePointer+vm.readUint16(f)].(*object.Integer).Value)
+
+		default:
+			err = newError(object.INTERNAL_ERROR, "unknown opcode %d", op)
+		}
+
+		if err != nil && !vm.raise(err, start) {
+			return err
+		}
+	}
+}
+
+// infix 计算二元运算，两个操作数都是整数时直接计算常见的运算
+func (vm *VM) infix(op code.Opcode, left, right object.Object) object.Object {
+	if l, ok := left.(*object.Integer); ok {
+		if r, ok := right.(*object.Integer); ok {
+			switch op {
+			case code.OpAdd:
+				return &object.Integer{Value: l.Value + r.Value}
+			case code.OpSub:
+				return &object.Integer{Value: l.Value - r.Value}
+			case code.OpMul:
+				return &object.Integer{Value: l.Value * r.Value}
+			case code.OpLess:
+				return nativeBool(l.Value < r.Value)
+			case code.OpGreater:
+				return nativeBool(l.Value > r.Value)
+			case code.OpLessEqual:
+				return nativeBool(l.Value <= r.Value)
+			case code.OpGreaterEqual:
+				return nativeBool(l.Value >= r.Value)
+			case code.OpEqual:
+				return nativeBool(l.Value == r.Value)
+			case code.OpNotEqual:
+				return nativeBool(l.Value != r.Value)
+			}
+		}
+	}
+	return evaluator.InfixOperation(infixOperators[op], left, right)
+}
+
+// call 调用栈中位于numArgs个参数之前的函数
+func (vm *VM) call(numArgs int) *object.Error {
+	callee := vm.stack[vm.sp-1-numArgs]
+
+	switch fn := callee.(type) {
+	case *object.Closure:
+		if numArgs != fn.Fn.NumParameters {
+			return newError(object.ARGUMENT_ERROR, "wrong number of arguments to %s: expected %d, got %d",
+				fn.DisplayName(), fn.Fn.NumParameters, numArgs)
+		}
+		if err := vm.rt.EnterCall(); err != nil {
+			return newError(object.LIMIT_ERROR, "%s", err)
+		}
+
+		basePointer := vm.sp - numArgs
+		vm.sp = basePointer + fn.Fn.NumLocals
+		vm.grow(vm.sp)
+		clear(vm.stack[basePointer+numArgs : vm.sp])
+		vm.frames = append(vm.frames, frame{cl: fn, basePointer: basePointer})
+		return nil
+
+	case *object.Builtin:
+		args := make([]object.Object, numArgs) //内置函数可能保存参数，不能直接使用栈
+		copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+		return vm.returnFromGo(fn.Fn(vm.rt, args...), numArgs)
+
+	case *object.Function: //evaluator.Eval在与vm共用的环境中定义的函数
+		args := make([]object.Object, numArgs)
+		copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+		result := evaluator.Apply(fn, args, vm.rt)
+		if err, ok := result.(*object.Error); ok && len(err.Stack) > 0 && !err.Stack[len(err.Stack)-1].Pos.IsValid() {
+			f := vm.frames[len(vm.frames)-1]
+			err.Stack[len(err.Stack)-1].Pos = f.cl.Fn.PosAt(f.ip - 1)
+		}
+		return vm.returnFromGo(result, numArgs)
+
+	default:
+		return newError(object.TYPE_ERROR, "not a function: %s", callee.Type())
+	}
+}
+
+// returnFromGo 用Go函数的返回值替换栈中的函数和参数
+func (vm *VM) returnFromGo(result object.Object, numArgs int) *object.Error {
+	if err, ok := result.(*object.Error); ok {
+		return err
+	}
+	if result == nil {
+		result = evaluator.NULL
+	}
+	vm.sp -= numArgs + 1
+	vm.push(result)
+	return nil
+}
+
+// raise 把错误交给最近的try处理，沿途离开的函数记录到错误的调用栈中。
+// 没有try能处理错误时返回false，这时所有的函数调用都已经结束
+func (vm *VM) raise(err *object.Error, start int) bool {
+	if !err.Pos.IsValid() { //错误第一次抛出时记录产生它的指令的位置
+		err.Pos = vm.frames[len(vm.frames)-1].cl.Fn.PosAt(start)
+	}
+
+	for {
+		top := len(vm.frames) - 1
+		if n := len(vm.handlers); n > 0 && vm.handlers[n-1].frame == top && err.Catchable() {
+			h := vm.handlers[n-1]
+			vm.handlers = vm.handlers[:n-1]
+			vm.sp = h.sp
+			vm.push(err)
+			vm.frames[top].ip = h.addr
+			return true
+		}
+		if n := len(vm.handlers); n > 0 && vm.handlers[n-1].frame == top { //不能捕获的错误跳过所有try
+			vm.handlers = vm.handlers[:n-1]
+			continue
+		}
+		if top == 0 {
+			return false
+		}
+
+		caller := vm.frames[top-1]
+		err.Stack = append(err.Stack, object.Frame{
+			Function: vm.frames[top].cl.DisplayName(),
+			Pos:      caller.cl.Fn.PosAt(caller.ip - 1),
+		})
+		vm.frames = vm.frames[:top]
+		vm.rt.ExitCall()
+	}
+}
+
+// cell 返回保存在栈中第i个位置的变量的Cell，变量还不在Cell中时创建一个
+func (vm *VM) cell(i int) *object.Cell {
+	if cell, ok := vm.stack[i].(*object.Cell); ok {
+		return cell
+	}
+	cell := &object.Cell{Value: vm.stack[i]} //参数和还没有定义的变量
+	vm.stack[i] = cell
+	return cell
+}
+
+func (vm *VM) push(obj object.Object) {
+	if vm.sp >= len(vm.stack) {
+		vm.grow(vm.sp + 1)
+	}
+	vm.stack[vm.sp] = obj
+	vm.sp++
+}
+
+// grow 保证栈至少有size个位置
+func (vm *VM) grow(size int) {
+	if size <= len(vm.stack) {
+		return
+	}
+	stack := make([]object.Object, max(size, 2*len(vm.stack)))
+	copy(stack, vm.stack)
+	vm.stack = stack
+}
+
+func (vm *VM) readUint16(f *frame) int {
+	v := int(code.ReadUint16(f.cl.Fn.Instructions[f.ip:]))
+	f.ip += 2
+	return v
+}
+
+func (vm *VM) readUint8(f *frame) int {
+	v := int(code.ReadUint8(f.cl.Fn.Instructions[f.ip:]))
+	f.ip++
+	return v
+}
+
+// buildHash 用栈中交替排列的键和值创建哈希表
+func buildHash(items []object.Object) (*object.Hash, *object.Error) {
+	pairs := make(map[object.HashKey]object.HashPair, len(items)/2)
+	for i := 0; i < len(items); i += 2 {
+		key, value := items[i], items[i+1]
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return nil, newError(object.TYPE_ERROR, "unusable as hash key: %s", key.Type())
+		}
+		pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+	return &object.Hash{Pairs: pairs}, nil
+}
+
+func nativeBool(input bool) *object.Boolean {
+	if input {
+		return evaluator.TRUE
+	}
+	return evaluator.FALSE
+}
+
+func newError(kind string, format string, a ...interface{}) *object.Error {
+	return &object.Error{Kind: kind, Message: fmt.Sprintf(format, a...)}
+}
@@ -0,0 +1,341 @@
+package vm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"my.com/myfile/ast"
+	"my.com/myfile/evaluator"
+	"my.com/myfile/lexer"
+	"my.com/myfile/object"
+	"my.com/myfile/parser"
+)
+
+// backendTests 每个程序都分别用求值器和vm执行，两者的结果、错误和输出必须完全相同
+var backendTests = []string{
+	// 字面量和运算
+	`1 + 2 * 3 - 4 / 2`,
+	`7 % 3; 2 ** 10; 2 ** -1`,
+	`1.5 + 2; 10 / 4.0; 3 % 2.5`,
+	`5 & 3; 5 | 3; 5 ^ 3; 1 << 4; 256 >> 2; ~5`,
+	`-5; --5; -1.5; !true; !!5; !null`,
+	`1 < 2; 2 > 1; 1 <= 1; 2 >= 3; 1 == 1; 1 != 1`,
+	`"a" + "b"; "a" < "b"; "a" == "a"; "ab" != "ab"`,
+	`true == true; true != false; 1 == true; [1] == [1]`,
+	`let a = [1]; a == a`,
+	`1 / 0`,
+	`5 % 0`,
+	`-9223372036854775807 - 1 / -1`,
+	`1 << -1`,
+	`true + false`,
+	`1 + "a"`,
+	`-"a"`,
+	`~1.5`,
+	`"a" - "b"`,
+
+	// 逻辑运算返回决定结果的操作数
+	`1 && 2; 0 && x; null && x; false || "d"; 5 || x; null || false`,
+	`let calls = 0; let f = fn() { calls += 1; true }; false && f(); true || f(); calls`,
+
+	// 变量和赋值
+	`let a = 5; let b = a * 2; a + b`,
+	`let a = 1; a = 2; a += 3; a -= 1; a *= 4; a /= 2; a`,
+	`let s = "a"; s += "b"; s`,
+	`x = 1`,
+	`x += 1`,
+	`y`,
+	`let a = 1; let a = a + 1; a`,
+	`let x = 1; let y = (x = 5); [x, y]`,
+	`let a = if (true) {}; a`,
+
+	// 数组和哈希表
+	`[1, 2 * 2, 3 + 3][1]`,
+	`let a = [1, 2, 3]; a[0] + a[1] + a[2]`,
+	`[1, 2, 3][3]; [1, 2, 3][-1]`,
+	`let a = [1, 2]; a[1] = 5; a[0] += 10; a`,
+	`let a = [1, 2]; a[2] = 5`,
+	`let a = [1, 2]; a["x"] = 5`,
+	`let s = "abc"; s[0] = "x"`,
+	`let s = "abc"; s[0]`,
+	`{"one": 1, "two": 2}["two"]`,
+	`let h = {}; h["a"] = 1; h["a"] += 1; h[true] = 3; [h["a"], h[true], h["b"]]`,
+	`{[1]: 2}`,
+	`{"a": 1}[[1]]`,
+	`let h = {}; h[fn() {}] = 1`,
+	`let a = [1]; a[0] = a; let h = {}; h["a"] = [a, h]; h`,
+	`let s = "héllo"; [len(s), s[len(s) - 1]]`,
+	`len("hello"); len([1, 2]); first([3, 4]); last([3, 4]); rest([1, 2, 3]); push([1], 2)`,
+	`len(1)`,
+	`len("a", "b")`,
+
+	// 条件
+	`if (1 < 2) { 10 } else { 20 }`,
+	`if (1 > 2) { 10 }`,
+	`if (null) { 1 } else { let a = 2 }`,
+	`if (true) { 1; 2; 3 }`,
+	`let x = 1; if (true) { let x = 2; x } ; x`,
+	`if (true) { let a = 1 }; a`,
+
+	// 函数和闭包
+	`let add = fn(a, b) { a + b }; add(1, 2)`,
+	`let f = fn() { return 5; 10 }; f()`,
+	`let f = fn() {}; f()`,
+	`let f = fn() { let a = 1 }; f()`,
+	`let f = fn(x) { x }; f(1, 2)`,
+	`fn named(x) { x }; named()`,
+	`let f = 5; f()`,
+	`fn() { 1 }`,
+	`fn add(a, b) { a + b }`,
+	`let adder = fn(x) { fn(y) { x + y } }; adder(2)(3)`,
+	`let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c(); c()`,
+	`let make = fn() { let a = 1; let get = fn() { a }; a = 5; get() }; make()`,
+	`let f = fn(x) { let g = fn() { x = x + 1 }; g(); g(); x }; f(10)`,
+	`let f = fn(a) { fn(b) { fn(c) { a + b + c } } }; f(1)(2)(3)`,
+	`let f = fn() { let g = fn() { h() }; let h = fn() { 42 }; g() }; f()`,
+	`let f = fn() { let g = fn() { y }; let r = g(); let y = 1; r }; f()`,
+	`let f = fn() { let g = fn() { y = 2 }; g(); let y = 1; y }; f()`,
+	`let x = 10; let f = fn() { let x = x + 1; x }; [f(), x]`,
+	`let x = 10; let f = fn() { x = x + 1 }; f(); f(); x`,
+	`let fib = fn(n) { if (n < 2) { return n } fib(n - 1) + fib(n - 2) }; fib(15)`,
+	`let fs = []; let i = 0; while (i < 3) { let j = i; fs = push(fs, fn() { j }); i += 1 }; [fs[0](), fs[1](), fs[2]()]`,
+	`let fs = []; for let i = 0 : i < 3 : i += 1 { fs = push(fs, fn() { i }) }; [fs[0](), fs[2]()]`,
+	`let apply = fn(f, x) { f(x) }; apply(fn(x) { x * 2 }, 21)`,
+	`return 5; 10`,
+	`let f = fn() { if (true) { if (true) { return 1 } } 2 }; f()`,
+
+	// 函数声明和提升
+	`double(4); fn double(x) { x * 2 }`,
+	`fn isEven(n) { if (n == 0) { true } else { isOdd(n - 1) } }
+fn isOdd(n) { if (n == 0) { false } else { isEven(n - 1) } }
+[isEven(10), isOdd(7)]`,
+	`let f = fn() { inner(); fn inner() { 7 } }; f()`,
+	`if (true) { g(); fn g() { 3 } }`,
+	`fn outer() { let v = 1; fn get() { v } ; v = 2; get() }; outer()`,
+	`let x = 1; fn x() { 2 }; x()`,
+	`let f = fn() { let y = 1; fn y() { 3 }; y() }; f()`,
+	`let r = []; for i in 0..2 { let h = 0; fn h() { i }; r = push(r, h()) }; r`,
+	`fn k() { 1 }`,
+
+	// 循环
+	`let i = 0; let sum = 0; while (i < 10) { sum += i; i += 1 }; sum`,
+	`let sum = 0; for let i = 0 : i < 5 : i += 1 { sum += i }; sum`,
+	`for let i = 0 : i < 3 : i += 1 { i }`,
+	`let i = 0; while (true) { i += 1; if (i == 5) { break } }; i`,
+	`let s = 0; for let i = 0 : i < 10 : i += 1 { if (i % 2 == 0) { continue } s += i }; s`,
+	`let n = 0
+outer: for let i = 0 : i < 5 : i += 1 {
+	for let j = 0 : j < 5 : j += 1 {
+		if (j == 2) { continue outer }
+		if (i == 3) { break outer }
+		n += 1
+	}
+}
+n`,
+	`let r = []; let i = 0
+outer: while (i < 3) {
+	i += 1
+	let j = 0
+	while (true) { j += 1; if (j > i) { continue outer } r = push(r, [i, j]) }
+}
+r`,
+	`let f = fn() { let i = 0; while (true) { i += 1; if (i > 3) { return i } } }; f()`,
+	`for let i = 0 : i < 3 : i += 1 { let x = 1 }; i`,
+	`let i = 0; while (i < 3) { let x = i; i += 1 }; x`,
+	`let f = fn() { for let i = 0 : i < 3 : i += 1 { if (i == 1) { return [i, 1 + 2 * (fn() { 3 })()] } } }; f()`,
+
+	// for-in
+	`let s = 0; for x in [1, 2, 3] { s += x }; s`,
+	`let r = []; for k, v in {"b": 2, "a": 1, 3: "c", true: 0} { r = push(r, [k, v]) }; r`,
+	`let r = []; for i, ch in "héllo" { r = push(r, i); r = push(r, ch) }; r`,
+	`let s = 0; for i, v in 5..8 { s += i * v }; [s, 0..3, 2..1]`,
+	`let s = 0; for i in 0..10 { if (i % 2 == 0) { continue } if (i > 6) { break } s += i }; s`,
+	`let r = []
+outer: for i in 0..3 {
+	for j, x in [10, 20, 30] {
+		if (j > i) { continue outer }
+		if (i == 2) { break outer }
+		r = push(r, x + i)
+	}
+}
+r`,
+	`let fns = []; for i in 0..3 { let d = i * 2; fns = push(fns, fn() { i + d }) }; [fns[0](), fns[2]()]`,
+	`let f = fn(a) { for x in a { if (x > 1) { return x } }; 0 }; [f([1, 5, 7]), f([])]`,
+	`let x = 42; for x in [1, 2] { let x = 3 }; x`,
+	`for x in [1] { }; x`,
+	`let n = 0; for x in 1..4 { try { if (x == 2) { continue } n += x } finally { puts(x) } }; n`,
+	`for x in 5 { }`,
+	`for x in "ab" { x + 1 }`,
+	`0.5..2`,
+
+	// 区间和切片
+	`let r = []; for i in 0..=3 { r = push(r, i) }; for i in step(10..0, -4) { r = push(r, i) }; r`,
+	`[0..=2, step(1..5, 2), 1 + 1..2 * 3, 3..1]`,
+	`step(0..1, 0)`,
+	`step(0..1, "a")`,
+	`[1, 2, 3][-1] + [1, 2, 3][-3]; [1][-2]`,
+	`let a = [1, 2, 3]; a[-1] = 9; a[-3] += 1; a`,
+	`let a = [1, 2, 3]; a[-4] = 0`,
+	`let a = [1, 2, 3, 4, 5]; [a[1:3], a[:2], a[3:], a[-2:], a[:-1], a[:], a[4:2], a[-10:100]]`,
+	`let a = [1, 2, 3]; let b = a[1:]; b[0] = 9; [a, b]`,
+	`let s = "héllo"; [s[:5], s[1:3], s[-3:], s[10:]]`,
+	`let s = "héllo"; [s[0], s[1], s[-1], s[-5]]`,
+	`try { "abc"[-4] } catch (e) { e["kind"] + ": " + e["message"] }`,
+	`let r = ""; let s = "abc"; for let i = 0 : i < 3 : i += 1 { r = s[i] + r }; r`,
+	`let f = fn() { [1, 2][1:"x"] }; f()`,
+	`5[1:]`,
+	`let lo = 1; let a = [1, 2, 3]; a[lo:lo + 1][0]`,
+
+	// try/catch/finally
+	`try { 1 } catch (e) { 2 }`,
+	`try { throw "boom" } catch (e) { e }`,
+	`try { throw "boom" } catch (e) { [e["message"], e["kind"], e["value"]] }`,
+	`try { 1 / 0 } catch (e) { e["kind"] + ": " + e["message"] }`,
+	`try { [1][5] } catch (e) { e["kind"] + ": " + e["message"] }`,
+	`try { [1, 2][-3] } catch (e) { e["message"] }`,
+	`let a = [1]; a[1]`,
+	`try { [1][5] = 1 } catch (e) { e["kind"] + ": " + e["message"] }`,
+	`try { throw {"code": 7} } catch (e) { e["value"]["code"] }`,
+	`throw "uncaught"`,
+	`throw 42`,
+	`let log = []; let r = try { log = push(log, "try"); 1 } finally { log = push(log, "finally") }; [r, log]`,
+	`let log = []; try { throw "x" } catch (e) { log = push(log, "catch") } finally { log = push(log, "finally") }; log`,
+	`let log = []; try { try { throw "x" } finally { log = push(log, "inner") } } catch (e) { log = push(log, e["message"]) }; log`,
+	`try { throw "a" } catch (e) { throw "b" } finally { 1 }`,
+	`let f = fn() { try { return 1 } finally { puts("cleanup") } }; f()`,
+	`let f = fn() { try { return 1 } finally { return 2 } }; f()`,
+	`let f = fn() { try { throw "x" } finally { return 3 } }; f()`,
+	`try { 1 } finally { throw "from finally" }`,
+	`let n = 0; while (n < 5) { try { n += 1; if (n == 2) { continue } if (n == 4) { break } } finally { puts(n) } }; n`,
+	`let n = 0; while (true) { try { throw "x" } catch (e) { n += 1; if (n == 3) { break } } finally { puts("f") } }; n`,
+	`let i = 0; while (i < 3) { i += 1; try { 1 } finally { continue } }; i`,
+	`let f = fn() { while (true) { try { return "r" } finally { break } }; "after" }; f()`,
+	`let e = 1; try { throw "x" } catch (e) { e = 2 }; e`,
+	`try { throw "x" } catch (err) { let y = 5; y + 1 }`,
+	`try { throw "x" } catch (e) { fn() { e["message"] } }()`,
+	`let fs = []; for let i = 0 : i < 2 : i += 1 { try { throw i } catch (e) { fs = push(fs, fn() { e["value"] }) } }; [fs[0](), fs[1]()]`,
+	`let f = fn() { throw "deep" }; let g = fn() { f() }; try { g() } catch (e) { e }`,
+	`let f = fn() { try { throw "x" } catch (e) { throw e } }; f()`,
+	`let f = fn() { throw "x" }
+let saved = try { f() } catch (e) { e }
+let g = fn() { throw saved }
+let again = try { g() } catch (e) { e }
+try { g() } catch (e) { };
+[saved["stack"], again["stack"]]`,
+	`try { missing } catch (e) { e["message"] }`,
+	`try { try { throw "in" } catch (e) { throw "out" } } catch (e) { e["message"] }`,
+	`let a = try { 1 } catch (e) { 2 } finally { 3 }; a`,
+
+	// 调用栈
+	`let inner = fn() { 1 + true }
+let middle = fn() { inner() }
+let outer = fn() { middle() }
+outer()`,
+	`fn a() { b() }
+fn b() { throw "from b" }
+a()`,
+	`let f = fn(n) { if (n == 0) { return undefinedName } f(n - 1) }; f(3)`,
+	`let f = fn() { 1 }; [1, 2, f(1)]`,
+	`let f = fn(n) { f(n + 1) }; f(0)`,
+
+	// 输出
+	`puts("hello"); puts(1, [2, 3]); puts()`,
+	`let r = puts("x"); r`,
+}
+
+type backendResult struct {
+	value  string
+	err    string
+	stdout string
+}
+
+func run(t *testing.T, input string, eval func(program *ast.Program, env *object.Environment) object.Object) backendResult {
+	t.Helper()
+	l := lexer.NewFile("test.wz", input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+
+	var stdout bytes.Buffer
+	rt := &object.Runtime{Stdin: strings.NewReader(""), Stdout: &stdout, Stderr: &stdout}
+	env := object.NewEnvironmentWithRuntime(rt)
+
+	result := backendResult{}
+	switch obj := eval(program, env).(type) {
+	case *object.Error:
+		result.err = obj.Inspect() + "\n" + obj.Traceback()
+	case nil:
+		result.value = "null" //以语句结尾的程序在求值器中为nil，在vm中可能为NULL
+	default:
+		result.value = obj.Inspect()
+	}
+	result.stdout = stdout.String()
+	return result
+}
+
+func TestBackendsAgree(t *testing.T) {
+	for _, input := range backendTests {
+		want := run(t, input, func(program *ast.Program, env *object.Environment) object.Object {
+			return evaluator.Eval(program, env)
+		})
+		got := run(t, input, Eval)
+
+		if got != want {
+			t.Errorf("backends disagree for\n%s\nevaluator: %+v\nvm:        %+v", input, want, got)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		input  string
+		limits object.Limits
+		want   string
+	}{
+		{"while (true) {}", object.Limits{MaxSteps: 1000}, "step limit exceeded"},
+		{"try { while (true) {} } catch (e) { 1 } finally { puts(1) }", object.Limits{MaxSteps: 1000}, "step limit exceeded"},
+		{"let f = fn(n) { try { f(n + 1) } catch (e) { 0 } }; f(0)", object.Limits{MaxDepth: 50}, "maximum recursion depth exceeded"},
+		{"while (true) {}", object.Limits{Timeout: 10 * time.Millisecond}, "execution timed out"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		var stdout bytes.Buffer
+		rt := &object.Runtime{Stdout: &stdout, Limits: tt.limits}
+
+		errObj, ok := Eval(program, object.NewEnvironmentWithRuntime(rt)).(*object.Error)
+		if !ok {
+			t.Errorf("%q: expected an error", tt.input)
+			continue
+		}
+		if errObj.Kind != object.LIMIT_ERROR || errObj.Message != tt.want {
+			t.Errorf("%q: wrong error. got=%s: %s, want=%s", tt.input, errObj.Kind, errObj.Message, tt.want)
+		}
+		if stdout.Len() != 0 { //超过限制之后不再执行finally
+			t.Errorf("%q: unexpected output %q", tt.input, stdout.String())
+		}
+		if rt.Steps() == 0 {
+			t.Errorf("%q: steps were not counted", tt.input)
+		}
+	}
+}
+
+// TestSharedEnvironment vm和求值器使用同一个环境时可以互相调用对方定义的函数
+func TestSharedEnvironment(t *testing.T) {
+	env := object.NewEnvironment()
+	evaluator.Eval(parser.New(lexer.New("let double = fn(x) { x * 2 }")).ParseProgram(), env)
+	Eval(parser.New(lexer.New("let inc = fn(x) { x + 1 }")).ParseProgram(), env)
+
+	got := Eval(parser.New(lexer.New("double(inc(4))")).ParseProgram(), env)
+	if got.Inspect() != "10" {
+		t.Errorf("vm: wrong result. got=%s", got.Inspect())
+	}
+	evaluator.Eval(parser.New(lexer.New("let x = 3")).ParseProgram(), env)
+	got = Eval(parser.New(lexer.New("x = double(x); x")).ParseProgram(), env)
+	if got.Inspect() != "6" {
+		t.Errorf("vm: wrong result after assignment. got=%s", got.Inspect())
+	}
+}